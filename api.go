@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/kelseyhightower/confd/resource/template"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// newAPIHandler returns the handler of the status API. It reports the
+// status of the template resources p processes and lets clients trigger a
+// run of them:
+//
+//	GET  /v1/templates         status of every template resource
+//	GET  /v1/templates/<name>  status of one template resource
+//	POST /v1/process           process every template resource
+//	POST /v1/process?template=<name>
+//	                           process one template resource
+//	GET  /metrics              Prometheus metrics
+//
+// Template resources are named after their path relative to conf.d.
+func newAPIHandler(p template.Processor) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/templates", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, p.Status())
+	})
+	mux.HandleFunc("/v1/templates/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/v1/templates/")
+		for _, s := range p.Status() {
+			if s.Name == name {
+				writeJSON(w, http.StatusOK, s)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, template.ErrUnknownTemplate.Error())
+	})
+	mux.HandleFunc("/v1/process", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if err := p.Trigger(r.URL.Query().Get("template")); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
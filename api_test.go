@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kelseyhightower/confd/resource/template"
+)
+
+type fakeProcessor struct {
+	statuses  []template.Status
+	triggered []string
+}
+
+func (p *fakeProcessor) Process() {}
+
+func (p *fakeProcessor) Reload() {}
+
+func (p *fakeProcessor) Status() []template.Status {
+	return p.statuses
+}
+
+func (p *fakeProcessor) Trigger(name string) error {
+	if name != "" && name != "foo.toml" {
+		return template.ErrUnknownTemplate
+	}
+	p.triggered = append(p.triggered, name)
+	return nil
+}
+
+func TestAPIHandler(t *testing.T) {
+	p := &fakeProcessor{statuses: []template.Status{{Name: "foo.toml", Dest: "/tmp/foo.conf"}}}
+	h := newAPIHandler(p)
+
+	tests := []struct {
+		method, url string
+		code        int
+	}{
+		{"GET", "/v1/templates", http.StatusOK},
+		{"GET", "/v1/templates/foo.toml", http.StatusOK},
+		{"GET", "/v1/templates/bar.toml", http.StatusNotFound},
+		{"POST", "/v1/templates", http.StatusMethodNotAllowed},
+		{"POST", "/v1/process", http.StatusAccepted},
+		{"POST", "/v1/process?template=foo.toml", http.StatusAccepted},
+		{"POST", "/v1/process?template=bar.toml", http.StatusNotFound},
+		{"GET", "/v1/process", http.StatusMethodNotAllowed},
+		{"GET", "/metrics", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.url, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.url, rec.Code, tt.code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/templates", nil))
+	var got []template.Status
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(got) != 1 || got[0].Name != "foo.toml" || got[0].Dest != "/tmp/foo.conf" {
+		t.Errorf("GET /v1/templates = %v", got)
+	}
+	if len(p.triggered) != 2 || p.triggered[0] != "" || p.triggered[1] != "foo.toml" {
+		t.Errorf("triggered = %q, want [\"\" \"foo.toml\"]", p.triggered)
+	}
+}
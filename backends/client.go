@@ -2,13 +2,17 @@ package backends
 
 import (
 	"errors"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/kelseyhightower/confd/backends/consul"
 	"github.com/kelseyhightower/confd/backends/dynamodb"
 	"github.com/kelseyhightower/confd/backends/env"
 	"github.com/kelseyhightower/confd/backends/etcdv3"
 	"github.com/kelseyhightower/confd/backends/file"
+	"github.com/kelseyhightower/confd/backends/http"
+	"github.com/kelseyhightower/confd/backends/overlay"
 	"github.com/kelseyhightower/confd/backends/rancher"
 	"github.com/kelseyhightower/confd/backends/redis"
 	"github.com/kelseyhightower/confd/backends/ssm"
@@ -24,6 +28,13 @@ type StoreClient interface {
 	WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error)
 }
 
+// The ErrorReporter interface is implemented by store clients that run
+// background tasks, such as renewing credentials, whose failures would
+// otherwise only show up on the next call to GetValues.
+type ErrorReporter interface {
+	Errors() <-chan error
+}
+
 // New is used to create a storage client based on our configuration.
 func New(config Config) (StoreClient, error) {
 
@@ -32,9 +43,12 @@ func New(config Config) (StoreClient, error) {
 	}
 	backendNodes := config.BackendNodes
 
-	if config.Backend == "file" {
+	switch config.Backend {
+	case "file":
 		log.Info("Backend source(s) set to " + strings.Join(config.YAMLFile, ", "))
-	} else {
+	case "overlay":
+		log.Info(fmt.Sprintf("Backend layers set to %d backends", len(config.Layers)))
+	default:
 		log.Info("Backend source(s) set to " + strings.Join(backendNodes, ", "))
 	}
 
@@ -60,6 +74,15 @@ func New(config Config) (StoreClient, error) {
 		return redis.NewRedisClient(backendNodes, config.ClientKey, config.Separator)
 	case "env":
 		return env.NewEnvClient()
+	case "http":
+		url := backendNodes[0]
+		if !strings.Contains(url, "://") {
+			url = config.Scheme + "://" + url
+		}
+		return http.New(url, config.Headers, config.AuthToken,
+			config.ClientCert, config.ClientKey, config.ClientCaKeys, config.ClientInsecure,
+			time.Duration(config.WatchInterval)*time.Second,
+		)
 	case "file":
 		return file.NewFileClient(config.YAMLFile, config.Filter)
 	case "vault":
@@ -76,13 +99,27 @@ func New(config Config) (StoreClient, error) {
 			"caCert":    config.ClientCaKeys,
 			"path":      config.Path,
 		}
-		return vault.New(backendNodes[0], config.AuthType, vaultConfig)
+		return vault.New(backendNodes[0], config.AuthType, vaultConfig, time.Duration(config.WatchInterval)*time.Second, config.Background)
 	case "dynamodb":
 		table := config.Table
 		log.Info("DynamoDB table set to " + table)
 		return dynamodb.NewDynamoDBClient(table)
 	case "ssm":
 		return ssm.New()
+	case "overlay":
+		if len(config.Layers) == 0 {
+			return nil, errors.New("no layers configured for the overlay backend")
+		}
+		layers := make([]overlay.StoreClient, len(config.Layers))
+		for i, l := range config.Layers {
+			l.Background = config.Background
+			c, err := New(l)
+			if err != nil {
+				return nil, err
+			}
+			layers[i] = c
+		}
+		return overlay.New(layers)
 	}
 	return nil, errors.New("Invalid backend")
 }
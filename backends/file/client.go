@@ -31,13 +31,18 @@ func NewFileClient(filepath []string, filter string) (*Client, error) {
 }
 
 func readFile(path string, vars map[string]string) error {
-	yamlMap := make(map[interface{}]interface{})
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
 	}
+	return Decode(data, vars)
+}
 
-	err = yaml.Unmarshal(data, &yamlMap)
+// Decode flattens the YAML or JSON document data into vars. Nested maps and
+// lists are flattened into keys, like /database/hosts/0.
+func Decode(data []byte, vars map[string]string) error {
+	yamlMap := make(map[interface{}]interface{})
+	err := yaml.Unmarshal(data, &yamlMap)
 	if err != nil {
 		return err
 	}
@@ -49,6 +54,16 @@ func readFile(path string, vars map[string]string) error {
 	return nil
 }
 
+// ReadValues reads the key/value pairs of the YAML or JSON file at path.
+// Nested maps and lists are flattened into keys, like /database/hosts/0.
+func ReadValues(path string) (map[string]string, error) {
+	vars := make(map[string]string)
+	if err := readFile(path, vars); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
 func (c *Client) GetValues(keys []string) (map[string]string, error) {
 	vars := make(map[string]string)
 	var filePaths []string
@@ -76,7 +91,7 @@ VarsLoop:
 		}
 		delete(vars, k)
 	}
-	log.Debug(fmt.Sprintf("Key Map: %#v", vars))
+	log.Debug(fmt.Sprintf("Key Map: %#v", log.RedactMap(vars)))
 	return vars, nil
 }
 
@@ -0,0 +1,195 @@
+package http
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelseyhightower/confd/backends/file"
+	"github.com/kelseyhightower/confd/log"
+)
+
+// Client fetches a JSON or YAML document from a URL and flattens it into
+// key/value pairs, like the file backend does for files.
+type Client struct {
+	url string
+	// safeURL is url with its password masked, for messages.
+	safeURL    string
+	header     http.Header
+	httpClient *http.Client
+	interval   time.Duration
+
+	mu      sync.Mutex
+	etag    string
+	sum     [sha256.Size]byte
+	vars    map[string]string
+	fetched time.Time
+	// index is incremented each time the document changes.
+	index uint64
+}
+
+// New returns a *http.Client fetching the document at url. headers are
+// sent with each request, as "Name: value", along with token as a bearer
+// token if it is set. WatchPrefix polls the document every interval.
+// It returns an error if the headers or the TLS settings are invalid.
+func New(url string, headers []string, token, cert, key, caCert string, insecure bool, interval time.Duration) (*Client, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		// The error would include the URL with its password.
+		return nil, fmt.Errorf("invalid URL: %s", err.(*neturl.Error).Err)
+	}
+	safeURL := u.Redacted()
+
+	header := make(http.Header)
+	for _, h := range headers {
+		i := strings.Index(h, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid header %q, expected \"Name: value\"", h)
+		}
+		header.Add(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	header.Set("Accept", "application/json, application/yaml;q=0.9, */*;q=0.8")
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if cert != "" && key != "" {
+		clientCert, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	if caCert != "" {
+		ca, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", caCert)
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	log.Info("Using HTTP backend URL: " + safeURL)
+	return &Client{
+		url:        url,
+		safeURL:    safeURL,
+		header:     header,
+		httpClient: &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		interval:   interval,
+	}, nil
+}
+
+// fetch gets the document unless it was fetched less than maxAge ago.
+// Once the document has an ETag, it is only downloaded again if it changed.
+func (c *Client) fetch(maxAge time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.vars != nil && time.Since(c.fetched) < maxAge {
+		return nil
+	}
+
+	req, err := http.NewRequest("GET", c.url, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range c.header {
+		req.Header[name] = values
+	}
+	if c.vars != nil && c.etag != "" {
+		req.Header.Set("If-None-Match", c.etag)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && c.vars != nil {
+		log.Debug("%s has not changed", c.safeURL)
+		c.fetched = time.Now()
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("GET %s: %s", c.safeURL, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	c.fetched = time.Now()
+	c.etag = resp.Header.Get("ETag")
+	// Servers without ETags send the whole document every time.
+	sum := sha256.Sum256(body)
+	if c.vars != nil && sum == c.sum {
+		return nil
+	}
+	vars := make(map[string]string)
+	if err := file.Decode(body, vars); err != nil {
+		return fmt.Errorf("cannot decode %s: %s", c.safeURL, err.Error())
+	}
+	c.sum = sum
+	c.vars = vars
+	c.index++
+	log.Debug("%s has changed", c.safeURL)
+	return nil
+}
+
+// GetValues fetches the document and returns the values of the keys
+// starting with one of keys.
+func (c *Client) GetValues(keys []string) (map[string]string, error) {
+	if err := c.fetch(0); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vars := make(map[string]string)
+	for k, v := range c.vars {
+		for _, key := range keys {
+			if strings.HasPrefix(k, key) {
+				vars[k] = v
+				break
+			}
+		}
+	}
+	return vars, nil
+}
+
+// WatchPrefix polls the document every interval until it changes. When
+// several template resources are watched, a document fetched less than
+// half an interval ago is not fetched again.
+func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	// return something > 0 to trigger an initial retrieval from the store
+	if waitIndex == 0 {
+		return 1, nil
+	}
+
+	for {
+		c.mu.Lock()
+		index := c.index
+		c.mu.Unlock()
+		if index > waitIndex {
+			return index, nil
+		}
+		select {
+		case <-stopChan:
+			return waitIndex, nil
+		case <-time.After(c.interval):
+		}
+		if err := c.fetch(c.interval / 2); err != nil {
+			return waitIndex, err
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer serves doc with an ETag, counting the requests it answered
+// with the whole document.
+type fakeServer struct {
+	mu    sync.Mutex
+	doc   string
+	etag  string
+	sent  int
+	auths []string
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.auths = append(f.auths, r.Header.Get("Authorization")+"|"+r.Header.Get("X-Api-Key"))
+	if f.etag != "" && r.Header.Get("If-None-Match") == f.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	f.sent++
+	if f.etag != "" {
+		w.Header().Set("ETag", f.etag)
+	}
+	w.Write([]byte(f.doc))
+}
+
+func (f *fakeServer) set(doc, etag string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.doc, f.etag = doc, etag
+}
+
+func TestGetValues(t *testing.T) {
+	f := &fakeServer{doc: `{"app": {"port": 8080, "hosts": ["a", "b"]}, "other": "x"}`, etag: `"1"`}
+	s := httptest.NewServer(f)
+	defer s.Close()
+	c, err := New(s.URL, []string{"X-Api-Key: secret"}, "token", "", "", "", false, time.Second)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := c.GetValues([]string{"/app"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		want := map[string]string{"/app/port": "8080", "/app/hosts/0": "a", "/app/hosts/1": "b"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("GetValues() = %v, want %v", got, want)
+		}
+	}
+	if f.sent != 1 {
+		t.Errorf("the document was sent %d times, want 1", f.sent)
+	}
+	for _, auth := range f.auths {
+		if auth != "Bearer token|secret" {
+			t.Errorf("got Authorization|X-Api-Key %q, want %q", auth, "Bearer token|secret")
+		}
+	}
+
+	f.set("app:\n  port: 9090\n", `"2"`)
+	got, err := c.GetValues([]string{"/app"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if want := map[string]string{"/app/port": "9090"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetValues() = %v, want %v", got, want)
+	}
+}
+
+func TestGetValuesError(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	defer s.Close()
+	c, _ := New(s.URL, nil, "", "", "", "", false, time.Second)
+	if _, err := c.GetValues([]string{"/app"}); err == nil {
+		t.Error("expected an error for a 404 response")
+	}
+	if _, err := New(s.URL, []string{"X-Api-Key"}, "", "", "", "", false, time.Second); err == nil {
+		t.Error("expected an error for an invalid header")
+	}
+}
+
+func TestWatchPrefix(t *testing.T) {
+	for _, etag := range []string{`"1"`, ""} {
+		f := &fakeServer{doc: `{"app": {"port": 8080}}`, etag: etag}
+		s := httptest.NewServer(f)
+		c, _ := New(s.URL, nil, "", "", "", "", false, 50*time.Millisecond)
+		stopChan := make(chan bool)
+
+		index, err := c.WatchPrefix("/app", []string{"/app"}, 0, stopChan)
+		if err != nil || index == 0 {
+			t.Fatalf("initial WatchPrefix() = %d, %v", index, err)
+		}
+		if _, err := c.GetValues([]string{"/app"}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		done := make(chan uint64)
+		go func() {
+			index, _ := c.WatchPrefix("/app", []string{"/app"}, index, stopChan)
+			done <- index
+		}()
+		select {
+		case <-done:
+			t.Fatalf("WatchPrefix() returned before the document changed (etag %q)", etag)
+		case <-time.After(200 * time.Millisecond):
+		}
+		if etag != "" {
+			etag = `"2"`
+		}
+		f.set(`{"app": {"port": 9090}}`, etag)
+		select {
+		case next := <-done:
+			if next <= index {
+				t.Errorf("WatchPrefix() = %d, want more than %d", next, index)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("WatchPrefix() did not return after the document changed (etag %q)", etag)
+		}
+
+		go func() {
+			index, _ := c.WatchPrefix("/app", []string{"/app"}, index+1, stopChan)
+			done <- index
+		}()
+		close(stopChan)
+		if got := <-done; got != index+1 {
+			t.Errorf("WatchPrefix() = %d after stop, want %d", got, index+1)
+		}
+		s.Close()
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/invalid.json":
+			w.Write([]byte("{"))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer s.Close()
+	withUser := strings.Replace(s.URL, "://", "://user:secret@", 1)
+
+	for _, url := range []string{withUser + "/config.json", withUser + "/invalid.json"} {
+		c, err := New(url, nil, "", "", "", "", false, time.Second)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if strings.Contains(c.safeURL, "secret") {
+			t.Errorf("safeURL = %q, want the password masked", c.safeURL)
+		}
+		_, err = c.GetValues([]string{"/"})
+		if err == nil || strings.Contains(err.Error(), "secret") {
+			t.Errorf("GetValues() = %v, want an error without the password", err)
+		}
+	}
+	if _, err := New("http://user:secret@[::1", nil, "", "", "", "", false, time.Second); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("New() = %v, want an error without the password", err)
+	}
+}
@@ -0,0 +1,40 @@
+package memory
+
+import (
+	"strings"
+)
+
+// Client serves fixed key/value pairs from memory, for rendering templates
+// against fixtures.
+type Client struct {
+	values map[string]string
+}
+
+// NewMemoryClient returns a new client serving values.
+func NewMemoryClient(values map[string]string) *Client {
+	return &Client{values: values}
+}
+
+// GetValues returns the values of the keys starting with one of keys.
+func (c *Client) GetValues(keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for k, v := range c.values {
+		for _, key := range keys {
+			if strings.HasPrefix(k, key) {
+				vars[k] = v
+				break
+			}
+		}
+	}
+	return vars, nil
+}
+
+// WatchPrefix returns once for the initial render, as the values never
+// change.
+func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	if waitIndex == 0 {
+		return 1, nil
+	}
+	<-stopChan
+	return waitIndex, nil
+}
@@ -0,0 +1,184 @@
+package overlay
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelseyhightower/confd/log"
+)
+
+// StoreClient is the interface the layers of an overlay implement. It is
+// the same as backends.StoreClient.
+type StoreClient interface {
+	GetValues(keys []string) (map[string]string, error)
+	WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error)
+}
+
+// Client merges the values of an ordered list of layers. Later layers win
+// key by key.
+type Client struct {
+	layers  []StoreClient
+	errors  chan error
+	watches map[string]*Watch
+	// revision is the last revision handed to a watch. Revisions are
+	// shared by all watches so that a watch replacing a stopped one starts
+	// above the revisions its callers have seen.
+	revision uint64
+	// Protect watches and revision
+	wm sync.Mutex
+}
+
+// A Watch follows a set of keys in every layer. Its revision is bumped
+// whenever one of the layers reports a change. It stops once the stopChan
+// of the WatchPrefix call that created it is closed.
+type Watch struct {
+	client   *Client
+	revision uint64
+	cond     chan struct{}
+	errs     chan error
+	done     chan struct{}
+	stopOnce sync.Once
+	rwl      sync.RWMutex
+}
+
+// New returns a client that merges the values of layers.
+func New(layers []StoreClient) (*Client, error) {
+	c := &Client{layers: layers, errors: make(chan error, 10), watches: make(map[string]*Watch)}
+	for _, l := range layers {
+		if r, ok := l.(interface{ Errors() <-chan error }); ok {
+			go c.forward(r.Errors())
+		}
+	}
+	return c, nil
+}
+
+// Errors returns a channel on which the layers report the failures of
+// their background tasks, such as renewing credentials.
+func (c *Client) Errors() <-chan error {
+	return c.errors
+}
+
+// forward passes the errors a layer reports on to the errors channel,
+// dropping them if nobody is listening.
+func (c *Client) forward(errs <-chan error) {
+	for err := range errs {
+		select {
+		case c.errors <- err:
+		default:
+		}
+	}
+}
+
+// GetValues queries every layer for keys and merges the results.
+func (c *Client) GetValues(keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, l := range c.layers {
+		values, err := l.GetValues(keys)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range values {
+			vars[k] = v
+		}
+	}
+	return vars, nil
+}
+
+// WatchPrefix returns once any of the layers reports a change to keys.
+func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	id := prefix + ":" + strings.Join(keys, ",")
+	for {
+		w := c.watch(id, prefix, keys, stopChan)
+		for stopped := false; !stopped; {
+			w.rwl.RLock()
+			revision, cond := w.revision, w.cond
+			w.rwl.RUnlock()
+			if revision > waitIndex {
+				return revision, nil
+			}
+			select {
+			case <-stopChan:
+				return waitIndex, nil
+			case err := <-w.errs:
+				return waitIndex, err
+			case <-cond:
+			case <-w.done:
+				// The caller that created the watch went away, follow
+				// the layers again.
+				stopped = true
+			}
+		}
+	}
+}
+
+// watch returns the running watch of id, starting a new one if needed. The
+// first revision of a new watch is above every revision seen before, so
+// that the initial call to WatchPrefix triggers a retrieval from the store.
+func (c *Client) watch(id, prefix string, keys []string, stopChan chan bool) *Watch {
+	c.wm.Lock()
+	defer c.wm.Unlock()
+	if w, ok := c.watches[id]; ok {
+		select {
+		case <-w.done:
+		default:
+			return w
+		}
+	}
+	c.revision++
+	w := &Watch{
+		client:   c,
+		revision: c.revision,
+		cond:     make(chan struct{}),
+		errs:     make(chan error, len(c.layers)),
+		done:     make(chan struct{}),
+	}
+	for _, l := range c.layers {
+		go w.follow(l, prefix, keys, stopChan)
+	}
+	c.watches[id] = w
+	return w
+}
+
+// follow watches keys in layer until stopChan is closed. The first call
+// to the layer only establishes its index, later returns are changes.
+func (w *Watch) follow(layer StoreClient, prefix string, keys []string, stopChan chan bool) {
+	var index uint64
+	for {
+		next, err := layer.WatchPrefix(prefix, keys, index, stopChan)
+		select {
+		case <-stopChan:
+			w.stopOnce.Do(func() { close(w.done) })
+			return
+		default:
+		}
+		if err != nil {
+			select {
+			case w.errs <- err:
+			default:
+			}
+			// Prevent backend errors from consuming all resources.
+			time.Sleep(time.Second * 2)
+			continue
+		}
+		if index != 0 {
+			log.Debug("Overlay layer changed under %s", prefix)
+			w.update()
+		}
+		index = next
+	}
+}
+
+// Update revision
+func (w *Watch) update() {
+	w.client.wm.Lock()
+	w.client.revision++
+	revision := w.client.revision
+	w.client.wm.Unlock()
+
+	w.rwl.Lock()
+	defer w.rwl.Unlock()
+	w.revision = revision
+	close(w.cond)
+	w.cond = make(chan struct{})
+}
@@ -0,0 +1,129 @@
+package overlay
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeLayer is a StoreClient serving fixed values. Sending on changes
+// makes a blocked WatchPrefix return.
+type fakeLayer struct {
+	values  map[string]string
+	changes chan bool
+}
+
+func (f *fakeLayer) GetValues(keys []string) (map[string]string, error) {
+	return f.values, nil
+}
+
+func (f *fakeLayer) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	if waitIndex == 0 {
+		return 1, nil
+	}
+	select {
+	case <-f.changes:
+		return waitIndex + 1, nil
+	case <-stopChan:
+		return waitIndex, nil
+	}
+}
+
+func TestGetValuesLaterLayersWin(t *testing.T) {
+	defaults := &fakeLayer{values: map[string]string{"/app/port": "80", "/app/host": "localhost"}}
+	overrides := &fakeLayer{values: map[string]string{"/app/port": "8080"}}
+	c, _ := New([]StoreClient{defaults, overrides})
+
+	got, err := c.GetValues([]string{"/app"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	want := map[string]string{"/app/port": "8080", "/app/host": "localhost"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetValues() = %v, want %v", got, want)
+	}
+}
+
+func TestWatchPrefixFiresOnAnyLayer(t *testing.T) {
+	a := &fakeLayer{changes: make(chan bool)}
+	b := &fakeLayer{changes: make(chan bool)}
+	c, _ := New([]StoreClient{a, b})
+	stopChan := make(chan bool)
+	defer close(stopChan)
+
+	index, err := c.WatchPrefix("/app", []string{"/app"}, 0, stopChan)
+	if err != nil || index == 0 {
+		t.Fatalf("initial WatchPrefix() = %d, %v", index, err)
+	}
+
+	done := make(chan uint64)
+	go func() {
+		next, _ := c.WatchPrefix("/app", []string{"/app"}, index, stopChan)
+		done <- next
+	}()
+	b.changes <- true
+	select {
+	case next := <-done:
+		if next <= index {
+			t.Errorf("WatchPrefix() = %d, want > %d", next, index)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchPrefix() did not return after a layer changed")
+	}
+}
+
+func TestWatchPrefixOutlivesStoppedCaller(t *testing.T) {
+	a := &fakeLayer{changes: make(chan bool)}
+	c, _ := New([]StoreClient{a})
+	stopFirst := make(chan bool)
+	stopSecond := make(chan bool)
+	defer close(stopSecond)
+
+	index, err := c.WatchPrefix("/app", []string{"/app"}, 0, stopFirst)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	done := make(chan uint64)
+	go func() {
+		next, _ := c.WatchPrefix("/app", []string{"/app"}, index, stopSecond)
+		done <- next
+	}()
+	close(stopFirst)
+	select {
+	case next := <-done:
+		if next <= index {
+			t.Errorf("WatchPrefix() = %d, want > %d", next, index)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchPrefix() did not return after the watch was stopped")
+	}
+}
+
+// reportingLayer is a fakeLayer reporting background errors.
+type reportingLayer struct {
+	fakeLayer
+	errs chan error
+}
+
+func (r *reportingLayer) Errors() <-chan error {
+	return r.errs
+}
+
+func TestErrorsFromLayers(t *testing.T) {
+	vault := &reportingLayer{errs: make(chan error, 1)}
+	c, err := New([]StoreClient{&fakeLayer{}, vault})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	vault.errs <- errors.New("cannot authenticate")
+	select {
+	case err := <-c.Errors():
+		if err.Error() != "cannot authenticate" {
+			t.Errorf("Errors() = %v, want the error of the layer", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the error of the layer was not reported")
+	}
+}
@@ -220,7 +220,7 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 		}
 	}
 
-	log.Debug(fmt.Sprintf("Key Map: %#v", vars))
+	log.Debug(fmt.Sprintf("Key Map: %#v", log.RedactMap(vars)))
 
 	return vars, nil
 }
@@ -1,22 +1,56 @@
 package vault
 
 import (
+	"crypto/sha1"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"path"
+	"strings"
+	"sync"
+	"time"
 
 	vaultapi "github.com/hashicorp/vault/api"
 	"github.com/kelseyhightower/confd/log"
 )
 
+// leased marks a path in watchState whose secret carries a lease. Such
+// secrets are re-fetched when the lease is about to expire rather than
+// compared on every poll, since reading them may issue new credentials.
+const leased = "leased"
+
+// versionParam pins a key to a version of a KV version 2 secret.
+const versionParam = "?version="
+
 // Client is a wrapper around the vault client
 type Client struct {
-	client *vaultapi.Client
+	client   *vaultapi.Client
+	authType string
+	params   map[string]string
+	interval time.Duration
+	errors   chan error
+
+	mu    sync.Mutex
+	state map[string]*watchState
+
+	mountsMu     sync.Mutex
+	mounts       map[string]int
+	mountsListed bool
+}
+
+// watchState records what GetValues last returned for a set of keys.
+type watchState struct {
+	// paths maps every path found under the keys to a fingerprint of
+	// its secret, or to leased.
+	paths map[string]string
+	// refresh is when the first leased secret has to be fetched again.
+	// It is zero if none of the secrets has a lease.
+	refresh time.Time
 }
 
 // get a
@@ -148,8 +182,10 @@ func getConfig(address, cert, key, caCert string) (*vaultapi.Config, error) {
 }
 
 // New returns an *vault.Client with a connection to named machines.
+// WatchPrefix polls Vault for changes every interval. If renew is set, the
+// token is renewed in the background for as long as confd runs.
 // It returns an error if a connection to the cluster cannot be made.
-func New(address, authType string, params map[string]string) (*Client, error) {
+func New(address, authType string, params map[string]string, interval time.Duration, renew bool) (*Client, error) {
 	if authType == "" {
 		return nil, errors.New("you have to set the auth type when using the vault backend")
 	}
@@ -168,27 +204,144 @@ func New(address, authType string, params map[string]string) (*Client, error) {
 	if err := authenticate(c, authType, params); err != nil {
 		return nil, err
 	}
-	return &Client{c}, nil
+	client := &Client{
+		client:   c,
+		authType: authType,
+		params:   params,
+		interval: interval,
+		errors:   make(chan error, 10),
+		state:    make(map[string]*watchState),
+	}
+	if renew {
+		go client.renew()
+	}
+	return client, nil
+}
+
+// Errors returns a channel on which failures to renew the token or to
+// authenticate again are reported.
+func (c *Client) Errors() <-chan error {
+	return c.errors
+}
+
+// report sends err on the errors channel, dropping it if nobody is
+// listening.
+func (c *Client) report(err error) {
+	log.Debug("vault: %s", err.Error())
+	select {
+	case c.errors <- err:
+	default:
+	}
+}
+
+// renew keeps the client token valid for as long as confd runs. Renewable
+// tokens are renewed once two thirds of their TTL have passed. If renewal
+// fails, or the token cannot be renewed, the configured auth method is run
+// again. Tokens without a TTL are left alone.
+func (c *Client) renew() {
+	retry := time.Second
+	for {
+		secret, err := c.client.Auth().Token().LookupSelf()
+		if err == nil {
+			err = c.renewAfter(secret)
+		}
+		if err == nil {
+			retry = time.Second
+			continue
+		}
+		if err == errNoTTL {
+			log.Debug("vault token has no TTL, it will not be renewed")
+			return
+		}
+		log.Warning(err.Error())
+		if err := c.reauthenticate(); err != nil {
+			c.report(err)
+			time.Sleep(retry)
+			if retry < time.Minute {
+				retry *= 2
+			}
+		}
+	}
+}
+
+var errNoTTL = errors.New("token has no TTL")
+
+// renewAfter waits until two thirds of the TTL of the token described by
+// secret have passed and then renews it.
+func (c *Client) renewAfter(secret *vaultapi.Secret) error {
+	ttl, err := secret.TokenTTL()
+	if err != nil {
+		return err
+	}
+	if ttl == 0 {
+		return errNoTTL
+	}
+	renewable, err := secret.TokenIsRenewable()
+	if err != nil {
+		return err
+	}
+	time.Sleep(ttl * 2 / 3)
+	if !renewable {
+		return errors.New("vault token is not renewable")
+	}
+	renewed, err := c.client.Auth().Token().RenewSelf(0)
+	if err != nil {
+		return fmt.Errorf("cannot renew vault token: %s", err.Error())
+	}
+	// Vault caps renewals at the max TTL of the token, so once the
+	// renewal no longer extends it we have to log in again.
+	if newTTL, err := renewed.TokenTTL(); err != nil || newTTL <= ttl/3 {
+		return errors.New("vault token has reached its maximum TTL")
+	}
+	log.Debug("renewed vault token")
+	return nil
+}
+
+// reauthenticate logs in again with the configured auth method. The new
+// token is obtained with a copy of the client and then swapped in, so that
+// concurrent reads keep using the old token meanwhile.
+func (c *Client) reauthenticate() error {
+	log.Info("Authenticating to vault again with auth backend %s", c.authType)
+	login, err := c.client.Clone()
+	if err != nil {
+		return fmt.Errorf("cannot authenticate to vault: %s", err.Error())
+	}
+	login.ClearToken()
+	if err := authenticate(login, c.authType, c.params); err != nil {
+		return fmt.Errorf("cannot authenticate to vault: %s", err.Error())
+	}
+	c.client.SetToken(login.Token())
+	return nil
 }
 
 // GetValues queries etcd for keys prefixed by prefix.
 func (c *Client) GetValues(keys []string) (map[string]string, error) {
-	branches := make(map[string]bool)
-	for _, key := range keys {
-		walkTree(c, key, branches)
-	}
+	branches, versions := c.branches(keys)
 	vars := make(map[string]string)
+	state := &watchState{paths: make(map[string]string)}
 	for key := range branches {
 		log.Debug("getting %s from vault", key)
-		resp, err := c.client.Logical().Read(key)
+		resp, fp, err := c.read(key, versions[key])
 
 		if err != nil {
 			log.Debug("there was an error extracting %s", key)
 			return nil, err
 		}
+		state.paths[key] = fp
 		if resp == nil || resp.Data == nil {
 			continue
 		}
+		// KV version 1 reads carry a lease duration too, but only as a
+		// refresh hint: secrets are leased if they have a lease ID.
+		if resp.LeaseID != "" && resp.LeaseDuration > 0 {
+			// Fetch the secret again after two thirds of its lease, leaving
+			// the template time to pick up the new one before it expires.
+			refresh := time.Now().Add(time.Duration(resp.LeaseDuration) * time.Second * 2 / 3)
+			if state.refresh.IsZero() || refresh.Before(state.refresh) {
+				state.refresh = refresh
+			}
+			state.paths[key] = leased
+		}
 
 		// if the key has only one string value
 		// treat it as a string and not a map of values
@@ -202,9 +355,157 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 			flatten(key, resp.Data, vars)
 		}
 	}
+	c.mu.Lock()
+	c.state[stateKey(keys)] = state
+	c.mu.Unlock()
 	return vars, nil
 }
 
+// branches returns every path found under keys, and the versions pinned
+// by keys of the form "/mount/secret?version=N". A pinned key refers to a
+// single secret, so its path is not listed.
+func (c *Client) branches(keys []string) (map[string]bool, map[string]string) {
+	branches := make(map[string]bool)
+	versions := make(map[string]string)
+	for _, key := range keys {
+		if i := strings.Index(key, versionParam); i != -1 {
+			key, version := strings.TrimSuffix(key[:i], "/"), key[i+len(versionParam):]
+			branches[key] = true
+			versions[key] = version
+			continue
+		}
+		walkTree(c, key, branches)
+	}
+	return branches, versions
+}
+
+// read fetches the secret at key. Secrets on KV version 2 mounts are read
+// through the data endpoint and returned without the data/metadata wrapper.
+// It also returns a fingerprint of the secret used to detect changes.
+func (c *Client) read(key, version string) (*vaultapi.Secret, string, error) {
+	p, v2 := c.apiPath(key, "data")
+	var params map[string][]string
+	if version != "" {
+		params = map[string][]string{"version": {version}}
+	}
+	resp, err := c.client.Logical().ReadWithData(p, params)
+	if err != nil || !v2 {
+		return resp, fingerprint(resp), err
+	}
+	if resp == nil || resp.Data == nil {
+		return nil, "", nil
+	}
+	metadata, _ := resp.Data["metadata"].(map[string]interface{})
+	data, _ := resp.Data["data"].(map[string]interface{})
+	secret := *resp
+	secret.Data = data
+	return &secret, fmt.Sprintf("version:%v", metadata["version"]), nil
+}
+
+// currentVersion returns the fingerprint of the latest version of the
+// secret at key on a KV version 2 mount, read from its metadata so the
+// secret itself is not fetched. ok is false for other mounts.
+func (c *Client) currentVersion(key string) (fp string, ok bool, err error) {
+	p, v2 := c.apiPath(key, "metadata")
+	if !v2 {
+		return "", false, nil
+	}
+	resp, err := c.client.Logical().Read(p)
+	if err != nil {
+		return "", true, err
+	}
+	if resp == nil || resp.Data == nil {
+		return "", true, nil
+	}
+	return fmt.Sprintf("version:%v", resp.Data["current_version"]), true, nil
+}
+
+// apiPath returns the API path for key. On KV version 2 mounts segment,
+// either "data" or "metadata", is inserted after the mount path. Keys
+// that already spell out the segment are left untouched.
+func (c *Client) apiPath(key, segment string) (string, bool) {
+	p := strings.TrimPrefix(key, "/")
+	mount, version := c.mountFor(p)
+	if version != 2 {
+		return key, false
+	}
+	rest := strings.TrimPrefix(p+"/", mount)
+	if strings.HasPrefix(rest, "data/") || strings.HasPrefix(rest, "metadata/") {
+		return key, false
+	}
+	return path.Join(mount, segment, rest), true
+}
+
+// mountFor returns the mount path of the secrets engine p lives on and its
+// KV version. Mounts are listed from sys/mounts on first use. If the token
+// may not list them each mount is looked up with the same preflight request
+// the vault CLI uses.
+func (c *Client) mountFor(p string) (string, int) {
+	c.mountsMu.Lock()
+	defer c.mountsMu.Unlock()
+
+	if c.mounts == nil {
+		c.mounts = make(map[string]int)
+		mounts, err := c.client.Sys().ListMounts()
+		if err != nil {
+			log.Debug("cannot list secrets engines, looking up mounts per path: %s", err.Error())
+		} else {
+			c.mountsListed = true
+			for mount, m := range mounts {
+				c.mounts[mount] = kvVersion(m.Type, m.Options["version"])
+			}
+		}
+	}
+
+	var found string
+	for mount := range c.mounts {
+		if strings.HasPrefix(p+"/", mount) && len(mount) > len(found) {
+			found = mount
+		}
+	}
+	if found != "" {
+		return found, c.mounts[found]
+	}
+	if c.mountsListed {
+		return "", 1
+	}
+
+	resp, err := c.client.Logical().Read("sys/internal/ui/mounts/" + p)
+	if err != nil || resp == nil || resp.Data == nil {
+		return "", 1
+	}
+	mount, _ := resp.Data["path"].(string)
+	if mount == "" {
+		return "", 1
+	}
+	typ, _ := resp.Data["type"].(string)
+	options, _ := resp.Data["options"].(map[string]interface{})
+	version, _ := options["version"].(string)
+	c.mounts[mount] = kvVersion(typ, version)
+	return mount, c.mounts[mount]
+}
+
+func kvVersion(typ, version string) int {
+	if typ == "kv" && version == "2" {
+		return 2
+	}
+	return 1
+}
+
+// fingerprint returns a digest of the data in a secret.
+func fingerprint(secret *vaultapi.Secret) string {
+	if secret == nil || secret.Data == nil {
+		return ""
+	}
+	js, _ := json.Marshal(secret.Data)
+	sum := sha1.Sum(js)
+	return hex.EncodeToString(sum[:])
+}
+
+func stateKey(keys []string) string {
+	return strings.Join(keys, ",")
+}
+
 // isKV checks if a given map has only one key of type string
 // if so, returns the value of that key
 func isKV(data map[string]interface{}) (string, bool) {
@@ -222,7 +523,7 @@ func isKV(data map[string]interface{}) (string, bool) {
 func flatten(key string, value interface{}, vars map[string]string) {
 	switch value.(type) {
 	case string:
-		log.Debug("setting key %s to: %s", key, value)
+		log.Debug("setting key %s to: %s", key, log.Redact(key, value.(string)))
 		vars[key] = value.(string)
 	case map[string]interface{}:
 		inner := value.(map[string]interface{})
@@ -249,7 +550,8 @@ func walkTree(c *Client, key string, branches map[string]bool) error {
 	}
 	branches[key] = true
 
-	resp, err := c.client.Logical().List(key)
+	listPath, _ := c.apiPath(key, "metadata")
+	resp, err := c.client.Logical().List(listPath)
 
 	if err != nil {
 		log.Debug("there was an error extracting %s", key)
@@ -282,8 +584,79 @@ func walkTree(c *Client, key string, branches map[string]bool) error {
 	return nil
 }
 
-// WatchPrefix - not implemented at the moment
+// changed reports whether the secrets under keys differ from state. Paths
+// holding leased secrets are only checked for existence.
+func (c *Client) changed(keys []string, state *watchState) (bool, error) {
+	branches, versions := c.branches(keys)
+	if len(branches) != len(state.paths) {
+		return true, nil
+	}
+	for key := range branches {
+		last, ok := state.paths[key]
+		if !ok {
+			return true, nil
+		}
+		if last == leased || versions[key] != "" {
+			continue
+		}
+		fp, ok, err := c.currentVersion(key)
+		if err != nil || !ok {
+			// The token may only be allowed to read the secret itself.
+			_, fp, err = c.read(key, "")
+		}
+		if err != nil {
+			return false, err
+		}
+		if fp != last {
+			log.Debug("secret %s has changed", key)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// WatchPrefix polls Vault every interval until the secrets under keys
+// change or a leased secret is due to be fetched again.
 func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
-	<-stopChan
-	return 0, nil
+	// return something > 0 to trigger an initial retrieval from the store
+	if waitIndex == 0 {
+		return 1, nil
+	}
+
+	for {
+		c.mu.Lock()
+		state := c.state[stateKey(keys)]
+		c.mu.Unlock()
+		if state == nil {
+			state = &watchState{}
+		}
+
+		wait := c.interval
+		if !state.refresh.IsZero() {
+			if d := time.Until(state.refresh); d < wait {
+				wait = d
+			}
+		}
+		// Don't hammer Vault if fetching an expiring secret keeps failing.
+		if wait < time.Second {
+			wait = time.Second
+		}
+		select {
+		case <-stopChan:
+			return waitIndex, nil
+		case <-time.After(wait):
+		}
+
+		if !state.refresh.IsZero() && !time.Now().Before(state.refresh) {
+			log.Debug("lease for secrets under %s is about to expire", prefix)
+			return waitIndex + 1, nil
+		}
+		changed, err := c.changed(keys, state)
+		if err != nil {
+			return waitIndex, err
+		}
+		if changed {
+			return waitIndex + 1, nil
+		}
+	}
 }
@@ -0,0 +1,481 @@
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/kelseyhightower/confd/log"
+)
+
+// fakeVault serves a KV version 2 mount at secret/ and a KV version 1
+// mount at kv1/.
+type fakeVault struct {
+	mu sync.Mutex
+	// forbidMounts makes listing sys/mounts fail, as with tokens that may
+	// only read secrets.
+	forbidMounts bool
+	// kv2 holds the versions of each secret on secret/, by name.
+	kv2 map[string][]map[string]interface{}
+	// kv1 holds the secrets on kv1/, by name, and leases the lease
+	// duration in seconds of those with a lease. Others are read with the
+	// refresh hint of KV version 1 and no lease ID, as from Vault.
+	kv1    map[string]map[string]interface{}
+	leases map[string]int
+	// lookups counts the token lookups. Logins return loginToken, once
+	// loginRelease is closed if it is set.
+	lookups      int
+	loginToken   string
+	loginRelease chan struct{}
+}
+
+func (f *fakeVault) addVersion(name string, data map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.kv2[name] = append(f.kv2[name], data)
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if strings.HasPrefix(p, "auth/userpass/login/") {
+		if f.loginRelease != nil {
+			<-f.loginRelease
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"auth": map[string]interface{}{"client_token": f.loginToken}})
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := r.URL.Query().Get("list") == "true"
+	switch {
+	case p == "auth/token/lookup-self":
+		f.lookups++
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"ttl": 0}})
+	case p == "sys/mounts":
+		if f.forbidMounts {
+			writeJSON(w, http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"secret/": map[string]interface{}{"type": "kv", "options": map[string]string{"version": "2"}},
+			"kv1/":    map[string]interface{}{"type": "kv", "options": map[string]string{}},
+			"sys/":    map[string]interface{}{"type": "system"},
+		}})
+	case strings.HasPrefix(p, "sys/internal/ui/mounts/"):
+		switch rest := strings.TrimPrefix(p, "sys/internal/ui/mounts/"); {
+		case strings.HasPrefix(rest, "secret/"):
+			writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+				"path": "secret/", "type": "kv", "options": map[string]string{"version": "2"},
+			}})
+		case strings.HasPrefix(rest, "kv1/"):
+			writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+				"path": "kv1/", "type": "kv", "options": nil,
+			}})
+		default:
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+		}
+	case strings.HasPrefix(p, "secret/metadata/") && list:
+		f.list(w, f.kv2Names(), strings.TrimPrefix(p, "secret/metadata/"))
+	case strings.HasPrefix(p, "secret/metadata/"):
+		versions := f.kv2[strings.TrimPrefix(p, "secret/metadata/")]
+		if len(versions) == 0 {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"current_version": len(versions)}})
+	case strings.HasPrefix(p, "secret/data/"):
+		versions := f.kv2[strings.TrimPrefix(p, "secret/data/")]
+		v := len(versions)
+		if q := r.URL.Query().Get("version"); q != "" {
+			v, _ = strconv.Atoi(q)
+		}
+		if v < 1 || v > len(versions) {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"data":     versions[v-1],
+			"metadata": map[string]interface{}{"version": v},
+		}})
+	case strings.HasPrefix(p, "kv1/") && list:
+		var names []string
+		for name := range f.kv1 {
+			names = append(names, name)
+		}
+		f.list(w, names, strings.TrimPrefix(p, "kv1/"))
+	case strings.HasPrefix(p, "kv1/"):
+		name := strings.TrimPrefix(p, "kv1/")
+		data, ok := f.kv1[name]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		if lease := f.leases[name]; lease > 0 {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"data": data, "lease_id": "kv1/" + name + "/lease", "lease_duration": lease})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": data, "lease_duration": 2764800})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+	}
+}
+
+func (f *fakeVault) kv2Names() []string {
+	var names []string
+	for name := range f.kv2 {
+		names = append(names, name)
+	}
+	return names
+}
+
+// list answers a LIST request for dir with the entries below it, folders
+// ending with a slash.
+func (f *fakeVault) list(w http.ResponseWriter, names []string, dir string) {
+	dir = strings.TrimSuffix(dir, "/")
+	seen := make(map[string]bool)
+	var keys []interface{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, dir+"/") {
+			continue
+		}
+		entry := strings.TrimPrefix(name, dir+"/")
+		if i := strings.Index(entry, "/"); i != -1 {
+			entry = entry[:i+1]
+		}
+		if !seen[entry] {
+			seen[entry] = true
+			keys = append(keys, entry)
+		}
+	}
+	if len(keys) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// newTestClient returns a client of a fake Vault server serving f, and a
+// function stopping the server.
+func newTestClient(t *testing.T, f *fakeVault, interval time.Duration) (*Client, func()) {
+	if f.kv2 == nil {
+		f.kv2 = make(map[string][]map[string]interface{})
+	}
+	s := httptest.NewServer(f)
+	c, err := vaultapi.NewClient(&vaultapi.Config{Address: s.URL})
+	if err != nil {
+		s.Close()
+		t.Fatal(err.Error())
+	}
+	c.SetToken("test")
+	return &Client{
+		client:   c,
+		interval: interval,
+		errors:   make(chan error, 10),
+		state:    make(map[string]*watchState),
+	}, s.Close
+}
+
+func TestWatchPrefix(t *testing.T) {
+	f := &fakeVault{}
+	c, stop := newTestClient(t, f, 50*time.Millisecond)
+	defer stop()
+	f.addVersion("app/db", map[string]interface{}{"password": "a"})
+	keys := []string{"/secret/app"}
+
+	index, err := c.WatchPrefix("/", keys, 0, nil)
+	if err != nil || index != 1 {
+		t.Fatalf("initial WatchPrefix() = %d, %v, want 1", index, err)
+	}
+	vars, err := c.GetValues(keys)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if want := map[string]string{"/secret/app/db": `{"password":"a"}`, "/secret/app/db/password": "a"}; !reflect.DeepEqual(vars, want) {
+		t.Errorf("GetValues() = %v, want %v", vars, want)
+	}
+
+	stopChan := make(chan bool)
+	done := make(chan uint64)
+	go func() {
+		index, err := c.WatchPrefix("/", keys, 1, stopChan)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		done <- index
+	}()
+	// The secret did not change for a few polls.
+	select {
+	case index := <-done:
+		t.Fatalf("WatchPrefix() = %d before the secret changed", index)
+	case <-time.After(1500 * time.Millisecond):
+	}
+	f.addVersion("app/db", map[string]interface{}{"password": "b"})
+	select {
+	case index := <-done:
+		if index != 2 {
+			t.Errorf("WatchPrefix() = %d, want 2", index)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchPrefix() did not return after the secret changed")
+	}
+
+	// A new secret under the keys is a change too.
+	if _, err := c.GetValues(keys); err != nil {
+		t.Fatal(err.Error())
+	}
+	f.addVersion("app/cache", map[string]interface{}{"value": "redis"})
+	if index, err := c.WatchPrefix("/", keys, 2, stopChan); err != nil || index != 3 {
+		t.Errorf("WatchPrefix() = %d, %v, want 3", index, err)
+	}
+
+	go func() {
+		index, _ := c.WatchPrefix("/", keys, 3, stopChan)
+		done <- index
+	}()
+	close(stopChan)
+	if index := <-done; index != 3 {
+		t.Errorf("WatchPrefix() = %d after stopChan was closed, want 3", index)
+	}
+}
+
+func TestWatchPrefixKV1(t *testing.T) {
+	f := &fakeVault{kv1: map[string]map[string]interface{}{"app/db": {"password": "a"}}}
+	c, stop := newTestClient(t, f, 50*time.Millisecond)
+	defer stop()
+	keys := []string{"/kv1/app"}
+	if _, err := c.GetValues(keys); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	done := make(chan uint64)
+	go func() {
+		index, err := c.WatchPrefix("/", keys, 1, make(chan bool))
+		if err != nil {
+			t.Error(err.Error())
+		}
+		done <- index
+	}()
+	select {
+	case index := <-done:
+		t.Fatalf("WatchPrefix() = %d before the secret changed", index)
+	case <-time.After(500 * time.Millisecond):
+	}
+	f.mu.Lock()
+	f.kv1["app/db"] = map[string]interface{}{"password": "b"}
+	f.mu.Unlock()
+	select {
+	case index := <-done:
+		if index != 2 {
+			t.Errorf("WatchPrefix() = %d, want 2", index)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchPrefix() did not return after the secret changed")
+	}
+}
+
+func TestWatchPrefixLease(t *testing.T) {
+	f := &fakeVault{
+		kv1:    map[string]map[string]interface{}{"db/creds": {"username": "u1", "password": "p1"}},
+		leases: map[string]int{"db/creds": 1},
+	}
+	c, stop := newTestClient(t, f, time.Hour)
+	defer stop()
+	keys := []string{"/kv1/db"}
+	if _, err := c.GetValues(keys); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// The leased secret is fetched again before its lease expires, although
+	// it did not change and the poll interval did not pass.
+	start := time.Now()
+	index, err := c.WatchPrefix("/", keys, 1, make(chan bool))
+	if err != nil || index != 2 {
+		t.Errorf("WatchPrefix() = %d, %v, want 2", index, err)
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("WatchPrefix() returned after %s, want before the lease expires", d)
+	}
+}
+
+func TestKVVersion(t *testing.T) {
+	tests := []struct {
+		typ, version string
+		want         int
+	}{
+		{"kv", "2", 2},
+		{"kv", "1", 1},
+		{"kv", "", 1},
+		{"generic", "2", 1},
+		{"database", "", 1},
+	}
+	for _, tt := range tests {
+		if got := kvVersion(tt.typ, tt.version); got != tt.want {
+			t.Errorf("kvVersion(%q, %q) = %d, want %d", tt.typ, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestAPIPath(t *testing.T) {
+	c := &Client{
+		mounts:       map[string]int{"secret/": 2, "secret/team/": 1, "kv1/": 1},
+		mountsListed: true,
+	}
+	tests := []struct {
+		key, segment string
+		want         string
+		v2           bool
+	}{
+		{"/secret/app/db", "data", "secret/data/app/db", true},
+		{"/secret/app/db", "metadata", "secret/metadata/app/db", true},
+		{"/secret/app/", "metadata", "secret/metadata/app", true},
+		{"/secret", "metadata", "secret/metadata", true},
+		// Keys spelling out the segment are left untouched.
+		{"/secret/data/app/db", "data", "/secret/data/app/db", false},
+		{"/secret/metadata/app", "metadata", "/secret/metadata/app", false},
+		// The longest mount wins.
+		{"/secret/team/app", "data", "/secret/team/app", false},
+		{"/kv1/app", "data", "/kv1/app", false},
+		{"/other/app", "data", "/other/app", false},
+		// A mount is matched on whole path elements.
+		{"/secrets/app", "data", "/secrets/app", false},
+	}
+	for _, tt := range tests {
+		got, v2 := c.apiPath(tt.key, tt.segment)
+		if got != tt.want || v2 != tt.v2 {
+			t.Errorf("apiPath(%q, %q) = %q, %v, want %q, %v", tt.key, tt.segment, got, v2, tt.want, tt.v2)
+		}
+	}
+}
+
+func TestMountFor(t *testing.T) {
+	for _, forbid := range []bool{false, true} {
+		f := &fakeVault{forbidMounts: forbid}
+		c, stop := newTestClient(t, f, time.Minute)
+		tests := []struct {
+			path    string
+			mount   string
+			version int
+		}{
+			{"secret/app/db", "secret/", 2},
+			{"kv1/app", "kv1/", 1},
+			{"unknown/app", "", 1},
+		}
+		for _, tt := range tests {
+			mount, version := c.mountFor(tt.path)
+			if mount != tt.mount || version != tt.version {
+				t.Errorf("mountFor(%q) = %q, %d, want %q, %d (sys/mounts forbidden: %v)", tt.path, mount, version, tt.mount, tt.version, forbid)
+			}
+		}
+		stop()
+	}
+}
+
+func TestBranches(t *testing.T) {
+	f := &fakeVault{}
+	c, stop := newTestClient(t, f, time.Minute)
+	defer stop()
+	f.addVersion("app/db", map[string]interface{}{"password": "a"})
+	f.addVersion("app/tls/cert", map[string]interface{}{"value": "pem"})
+
+	branches, versions := c.branches([]string{"/secret/app", "/secret/pinned/?version=3"})
+	var got []string
+	for b := range branches {
+		got = append(got, b)
+	}
+	sort.Strings(got)
+	want := []string{"/secret/app", "/secret/app/db", "/secret/app/tls", "/secret/app/tls/cert", "/secret/pinned"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("branches() = %v, want %v", got, want)
+	}
+	if want := map[string]string{"/secret/pinned": "3"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("versions = %v, want %v", versions, want)
+	}
+}
+
+func TestGetValuesVersion(t *testing.T) {
+	f := &fakeVault{}
+	c, stop := newTestClient(t, f, time.Minute)
+	defer stop()
+	f.addVersion("app/db", map[string]interface{}{"value": "old"})
+	f.addVersion("app/db", map[string]interface{}{"value": "new"})
+
+	tests := []struct {
+		key  string
+		want map[string]string
+	}{
+		{"/secret/app/db", map[string]string{"/secret/app/db": "new"}},
+		{"/secret/app/db?version=1", map[string]string{"/secret/app/db": "old"}},
+		{"/secret/app/db/?version=2", map[string]string{"/secret/app/db": "new"}},
+		{"/secret/app/db?version=3", map[string]string{}},
+	}
+	for _, tt := range tests {
+		got, err := c.GetValues([]string{tt.key})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetValues(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestReauthenticate(t *testing.T) {
+	log.SetLevel("warn")
+	f := &fakeVault{loginToken: "new", loginRelease: make(chan struct{})}
+	c, stop := newTestClient(t, f, time.Minute)
+	defer stop()
+	c.authType = "userpass"
+	c.params = map[string]string{"username": "confd", "password": "secret"}
+
+	done := make(chan error)
+	go func() {
+		done <- c.reauthenticate()
+	}()
+	// Reads keep the old token while logging in.
+	time.Sleep(100 * time.Millisecond)
+	if got := c.client.Token(); got != "test" {
+		t.Errorf("token = %q while logging in, want %q", got, "test")
+	}
+	close(f.loginRelease)
+	if err := <-done; err != nil {
+		t.Fatal(err.Error())
+	}
+	if got := c.client.Token(); got != "new" {
+		t.Errorf("token = %q after logging in, want %q", got, "new")
+	}
+}
+
+func TestNewRenew(t *testing.T) {
+	log.SetLevel("warn")
+	for _, renew := range []bool{false, true} {
+		f := &fakeVault{}
+		s := httptest.NewServer(f)
+		if _, err := New(s.URL, "token", map[string]string{"token": "test"}, time.Minute, renew); err != nil {
+			t.Fatal(err.Error())
+		}
+		// With renew, the renewer looks the token up again and stops as it
+		// has no TTL.
+		time.Sleep(200 * time.Millisecond)
+		f.mu.Lock()
+		lookups := f.lookups
+		f.mu.Unlock()
+		if want := map[bool]int{false: 1, true: 2}[renew]; lookups != want {
+			t.Errorf("got %d token lookups with renew %v, want %d", lookups, renew, want)
+		}
+		s.Close()
+	}
+}
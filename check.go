@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/log"
+	"github.com/kelseyhightower/confd/resource/template"
+)
+
+// validate checks the template resources and templates in the confdir
+// without contacting the backends, and prints the problems found.
+// It returns the exit status: 1 if there are problems, 0 otherwise.
+func validate() int {
+	config.TemplateConfig.StoreClients = make(map[string]backends.StoreClient)
+	for name := range config.Backends {
+		config.TemplateConfig.StoreClients[name] = nil
+	}
+	problems, err := template.Validate(config.TemplateConfig)
+	if err != nil {
+		log.Error(err.Error())
+		return 1
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%d problems found\n", len(problems))
+		return 1
+	}
+	return 0
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
@@ -14,7 +15,13 @@ import (
 )
 
 func main() {
-	flag.Parse()
+	var command string
+	if len(os.Args) > 1 && (os.Args[1] == "check" || os.Args[1] == "render") {
+		command = os.Args[1]
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
 	if config.PrintVersion {
 		fmt.Printf("confd %s (Git SHA: %s, Go Version: %s)\n", Version, GitSHA, runtime.Version())
 		os.Exit(0)
@@ -23,42 +30,125 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
+	if command == "" && flag.NArg() > 0 {
+		config.Exec = flag.Args()
+	}
+	if len(config.Exec) > 0 && os.Getpid() == 1 && os.Getenv(reaperEnv) == "" {
+		os.Exit(reap())
+	}
+	os.Unsetenv(reaperEnv)
+
+	if config.Validate || command == "check" {
+		os.Exit(validate())
+	}
+	if command == "render" {
+		os.Exit(render(flag.Args()))
+	}
+
 	log.Info("Starting confd")
 
+	config.BackendsConfig.Background = !config.OneTime
 	storeClient, err := backends.New(config.BackendsConfig)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 
 	config.TemplateConfig.StoreClient = storeClient
-	if config.OneTime {
+	storeClients := []backends.StoreClient{storeClient}
+
+	config.TemplateConfig.StoreClients = make(map[string]backends.StoreClient)
+	for name, b := range config.Backends {
+		b.Background = !config.OneTime
+		c, err := backends.New(b)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("Cannot create backend %s: %s", name, err.Error()))
+		}
+		config.TemplateConfig.StoreClients[name] = c
+		storeClients = append(storeClients, c)
+	}
+
+	var sup *supervisor
+	if len(config.Exec) > 0 {
+		if sup, err = newSupervisor(config.Exec, config.ExecReloadSignal); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	if config.OneTime || sup != nil {
 		if err := template.Process(config.TemplateConfig); err != nil {
 			log.Fatal(err.Error())
 		}
-		os.Exit(0)
+		if sup == nil {
+			os.Exit(0)
+		}
+		if err := sup.start(); err != nil {
+			log.Fatal(fmt.Sprintf("Cannot run %s: %s", config.Exec[0], err.Error()))
+		}
+		config.TemplateConfig.Updated = sup.updated
 	}
 
 	stopChan := make(chan bool)
 	doneChan := make(chan bool)
 	errChan := make(chan error, 10)
 
+	for _, c := range storeClients {
+		if r, ok := c.(backends.ErrorReporter); ok {
+			go func() {
+				for err := range r.Errors() {
+					errChan <- err
+				}
+			}()
+		}
+	}
+
 	var processor template.Processor
 	switch {
+	case config.OneTime:
+		// Only the child is supervised.
 	case config.Watch:
 		processor = template.WatchProcessor(config.TemplateConfig, stopChan, doneChan, errChan)
 	default:
 		processor = template.IntervalProcessor(config.TemplateConfig, stopChan, doneChan, errChan, config.Interval)
 	}
 
-	go processor.Process()
+	if processor != nil {
+		go processor.Process()
+	}
+
+	if config.Listen != "" && processor != nil {
+		go func() {
+			log.Info("Serving the status API on " + config.Listen)
+			if err := http.ListenAndServe(config.Listen, newAPIHandler(processor)); err != nil {
+				log.Fatal(err.Error())
+			}
+		}()
+	}
 
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	if sup != nil {
+		signal.Notify(signalChan, append(forwardedSignals, syscall.SIGHUP)...)
+	} else {
+		signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	}
 	for {
 		select {
 		case err := <-errChan:
-			log.Error(err.Error())
+			log.WithError(err).Error(err.Error())
+		case status := <-sup.Exited():
+			os.Exit(status)
 		case s := <-signalChan:
+			if s == syscall.SIGHUP {
+				if processor != nil {
+					log.Info("Captured SIGHUP. Reloading template resources...")
+					processor.Reload()
+				}
+				continue
+			}
+			if sup != nil {
+				log.Info(fmt.Sprintf("Captured %v. Forwarding it to %s...", s, config.Exec[0]))
+				sup.forward(s, stopSignals[s])
+				continue
+			}
 			log.Info(fmt.Sprintf("Captured %v. Exiting...", s))
 			close(doneChan)
 		case <-doneChan:
@@ -23,14 +23,24 @@ type BackendsConfig = backends.Config
 type Config struct {
 	TemplateConfig
 	BackendsConfig
-	Interval     int    `toml:"interval"`
-	SRVDomain    string `toml:"srv_domain"`
-	SRVRecord    string `toml:"srv_record"`
-	LogLevel     string `toml:"log-level"`
-	Watch        bool   `toml:"watch"`
-	PrintVersion bool
-	ConfigFile   string
-	OneTime      bool
+	Backends         map[string]BackendsConfig `toml:"backends"`
+	Interval         int                       `toml:"interval"`
+	Listen           string                    `toml:"listen"`
+	SRVDomain        string                    `toml:"srv_domain"`
+	SRVRecord        string                    `toml:"srv_record"`
+	LogLevel         string                    `toml:"log-level"`
+	LogFormat        string                    `toml:"log-format"`
+	LogOutput        string                    `toml:"log-output"`
+	Watch            bool                      `toml:"watch"`
+	SensitiveKeys    []string                  `toml:"sensitive_keys"`
+	PrintVersion     bool
+	ConfigFile       string
+	OneTime          bool
+	Validate         bool
+	Fixture          string
+	Output           string
+	Exec             []string `toml:"exec"`
+	ExecReloadSignal string   `toml:"exec_reload_signal"`
 }
 
 var config Config
@@ -42,19 +52,32 @@ func init() {
 	flag.StringVar(&config.ClientCaKeys, "client-ca-keys", "", "client ca keys")
 	flag.StringVar(&config.ClientCert, "client-cert", "", "the client cert")
 	flag.StringVar(&config.ClientKey, "client-key", "", "the client key")
-	flag.BoolVar(&config.ClientInsecure, "client-insecure", false, "Allow connections to SSL sites without certs (only used with -backend=etcd)")
+	flag.BoolVar(&config.ClientInsecure, "client-insecure", false, "Allow connections to SSL sites without certs (only used with -backend=etcd and -backend=http)")
 	flag.StringVar(&config.ConfDir, "confdir", "/etc/confd", "confd conf directory")
 	flag.StringVar(&config.ConfigFile, "config-file", "/etc/confd/confd.toml", "the confd config file")
+	flag.BoolVar(&config.Diff, "diff", false, "print the changes to destination files as unified diffs (always enabled with -noop)")
 	flag.Var(&config.YAMLFile, "file", "the YAML file to watch for changes (only used with -backend=file)")
+	flag.StringVar(&config.ExecReloadSignal, "exec-reload-signal", "", "signal to send to the command run after the flags when destination files are updated, e.g. SIGHUP (restarts it if empty)")
+	flag.StringVar(&config.Fixture, "fixture", "", "the YAML or JSON file of key/value pairs to render templates with (only used with confd render)")
 	flag.StringVar(&config.Filter, "filter", "*", "files filter (only used with -backend=file)")
+	flag.Var(&config.Headers, "header", "HTTP header to send as \"Name: value\", can be given several times (only used with -backend=http)")
 	flag.IntVar(&config.Interval, "interval", 600, "backend polling interval")
 	flag.BoolVar(&config.KeepStageFile, "keep-stage-file", false, "keep staged files")
+	flag.StringVar(&config.Listen, "listen", "", "address to serve the status API on, e.g. 127.0.0.1:9100 (disabled if empty)")
+	flag.DurationVar(&config.MaxWait, "max-wait", 0, "maximum time to wait for the keys of a template resource to stop changing before rendering it (only used with -watch, defaults to 4 times -min-wait)")
+	flag.DurationVar(&config.MinWait, "min-wait", 0, "time the keys of a template resource must not change before rendering it, e.g. 2s (only used with -watch)")
 	flag.StringVar(&config.LogLevel, "log-level", "", "level which confd should log messages")
+	flag.StringVar(&config.LogOutput, "log-output", "", "where to write log messages: stderr, syslog or journald")
+	flag.StringVar(&config.LogFormat, "log-format", "", "format of log messages: text or json")
 	flag.Var(&config.BackendNodes, "node", "list of backend nodes")
 	flag.BoolVar(&config.Noop, "noop", false, "only show pending changes")
 	flag.BoolVar(&config.OneTime, "onetime", false, "run once and exit")
+	flag.StringVar(&config.Output, "output", "", "the directory to write rendered templates to, below their dest paths (only used with confd render, defaults to stdout)")
 	flag.StringVar(&config.Prefix, "prefix", "", "key path prefix")
+	flag.BoolVar(&config.Validate, "validate", false, "check the template resources and templates for errors without contacting the backends, and exit")
 	flag.BoolVar(&config.PrintVersion, "version", false, "print version and exit")
+	flag.BoolVar(&config.Rollback, "rollback", false, "restore the previous destination file and reload again if the reload command fails")
+	flag.StringVar(&config.SecretKeyring, "secret-keyring", "", "path to armored PGP secret keyring (for use with crypt functions)")
 	flag.StringVar(&config.Scheme, "scheme", "http", "the backend URI scheme for nodes retrieved from DNS SRV records (http or https)")
 	flag.StringVar(&config.SRVDomain, "srv-domain", "", "the name of the resource record")
 	flag.StringVar(&config.SRVRecord, "srv-record", "", "the SRV record to search for backends nodes. Example: _etcd-client._tcp.example.com")
@@ -70,6 +93,7 @@ func init() {
 	flag.StringVar(&config.Username, "username", "", "the username to authenticate as (only used with vault and etcd backends)")
 	flag.StringVar(&config.Password, "password", "", "the password to authenticate with (only used with vault and etcd backends)")
 	flag.BoolVar(&config.Watch, "watch", false, "enable watch support")
+	flag.IntVar(&config.WatchInterval, "watch-interval", 30, "polling interval in seconds for watching backends without native change notifications (only used with -backend=vault and -backend=http)")
 }
 
 // initConfig initializes the confd configuration by first setting defaults,
@@ -101,6 +125,18 @@ func initConfig() error {
 		log.SetLevel(config.LogLevel)
 	}
 
+	if err := log.SetFormat(config.LogFormat); err != nil {
+		return err
+	}
+
+	if err := log.SetOutput(config.LogOutput); err != nil {
+		return err
+	}
+
+	if err := log.SetSensitiveKeys(config.SensitiveKeys); err != nil {
+		return fmt.Errorf("invalid sensitive_keys pattern: %s", err.Error())
+	}
+
 	if config.SRVDomain != "" && config.SRVRecord == "" {
 		config.SRVRecord = fmt.Sprintf("_%s._tcp.%s.", config.Backend, config.SRVDomain)
 	}
@@ -125,46 +161,117 @@ func initConfig() error {
 		config.BackendNodes = srvNodes
 	}
 	if len(config.BackendNodes) == 0 {
-		switch config.Backend {
-		case "consul":
-			config.BackendNodes = []string{"127.0.0.1:8500"}
-		case "etcd":
-			peerstr := os.Getenv("ETCDCTL_PEERS")
-			if len(peerstr) > 0 {
-				config.BackendNodes = strings.Split(peerstr, ",")
-			} else {
-				config.BackendNodes = []string{"http://127.0.0.1:4001"}
-			}
-		case "etcdv3":
-			config.BackendNodes = []string{"127.0.0.1:2379"}
-		case "redis":
-			config.BackendNodes = []string{"127.0.0.1:6379"}
-		case "vault":
-			config.BackendNodes = []string{"http://127.0.0.1:8200"}
-		case "zookeeper":
-			config.BackendNodes = []string{"127.0.0.1:2181"}
-		}
+		config.BackendNodes = defaultBackendNodes(config.Backend)
 	}
 	// Initialize the storage client
 	log.Info("Backend set to " + config.Backend)
+	if err := checkBackendConfig(config.BackendsConfig); err != nil {
+		return err
+	}
+
+	if err := setLayerDefaults(&config.BackendsConfig); err != nil {
+		return err
+	}
+
+	for name, b := range config.Backends {
+		if b.Backend == "" {
+			return fmt.Errorf("no backend type configured for backend %s", name)
+		}
+		log.Info(fmt.Sprintf("Backend %s set to %s", name, b.Backend))
+		if err := setBackendDefaults(&b); err != nil {
+			return fmt.Errorf("backend %s: %s", name, err.Error())
+		}
+		config.Backends[name] = b
+	}
+	config.ConfigDir = filepath.Join(config.ConfDir, "conf.d")
+	config.TemplateDir = filepath.Join(config.ConfDir, "templates")
+	return nil
+}
+
+// defaultBackendNodes returns the nodes used for backend when none are
+// configured.
+func defaultBackendNodes(backend string) []string {
+	switch backend {
+	case "consul":
+		return []string{"127.0.0.1:8500"}
+	case "etcd":
+		peerstr := os.Getenv("ETCDCTL_PEERS")
+		if len(peerstr) > 0 {
+			return strings.Split(peerstr, ",")
+		}
+		return []string{"http://127.0.0.1:4001"}
+	case "etcdv3":
+		return []string{"127.0.0.1:2379"}
+	case "redis":
+		return []string{"127.0.0.1:6379"}
+	case "vault":
+		return []string{"http://127.0.0.1:8200"}
+	case "zookeeper":
+		return []string{"127.0.0.1:2181"}
+	}
+	return nil
+}
+
+// setBackendDefaults fills in the settings a named backend or overlay layer
+// leaves unset, and validates it.
+func setBackendDefaults(b *BackendsConfig) error {
+	if len(b.BackendNodes) == 0 {
+		b.BackendNodes = defaultBackendNodes(b.Backend)
+	}
+	if b.Scheme == "" {
+		b.Scheme = config.Scheme
+	}
+	if b.Filter == "" {
+		b.Filter = config.Filter
+	}
+	if b.WatchInterval == 0 {
+		b.WatchInterval = config.WatchInterval
+	}
+	if err := checkBackendConfig(*b); err != nil {
+		return err
+	}
+	return setLayerDefaults(b)
+}
 
+// setLayerDefaults sets the defaults of each layer of an overlay backend.
+func setLayerDefaults(b *BackendsConfig) error {
+	if b.Backend == "overlay" && len(b.Layers) == 0 {
+		return errors.New("no layers configured for the overlay backend")
+	}
+	for i := range b.Layers {
+		if b.Layers[i].Backend == "" {
+			return fmt.Errorf("no backend type configured for layer %d", i+1)
+		}
+		if err := setBackendDefaults(&b.Layers[i]); err != nil {
+			return fmt.Errorf("layer %d: %s", i+1, err.Error())
+		}
+	}
+	return nil
+}
+
+// checkBackendConfig validates the settings of a backend.
+func checkBackendConfig(b BackendsConfig) error {
 	if config.Watch {
 		unsupportedBackends := map[string]bool{
 			"dynamodb": true,
 			"ssm":      true,
 		}
 
-		if unsupportedBackends[config.Backend] {
-			log.Info(fmt.Sprintf("Watch is not supported for backend %s. Exiting...", config.Backend))
+		if unsupportedBackends[b.Backend] {
+			log.Info(fmt.Sprintf("Watch is not supported for backend %s. Exiting...", b.Backend))
 			os.Exit(1)
 		}
 	}
 
-	if config.Backend == "dynamodb" && config.Table == "" {
+	if b.Backend == "dynamodb" && b.Table == "" {
 		return errors.New("no DynamoDB table configured")
 	}
-	config.ConfigDir = filepath.Join(config.ConfDir, "conf.d")
-	config.TemplateDir = filepath.Join(config.ConfDir, "templates")
+	if b.Backend == "http" && len(b.BackendNodes) == 0 {
+		return errors.New("no URL configured for the http backend")
+	}
+	if (b.Backend == "http" || b.Backend == "vault") && b.WatchInterval <= 0 {
+		return fmt.Errorf("invalid watch interval %d for the %s backend: must be at least one second", b.WatchInterval, b.Backend)
+	}
 	return nil
 }
 
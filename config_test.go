@@ -11,10 +11,11 @@ func TestInitConfigDefaultConfig(t *testing.T) {
 	log.SetLevel("warn")
 	want := Config{
 		BackendsConfig: BackendsConfig{
-			Backend:      "etcd",
-			BackendNodes: []string{"http://127.0.0.1:4001"},
-			Scheme:       "http",
-			Filter:       "*",
+			Backend:       "etcd",
+			BackendNodes:  []string{"http://127.0.0.1:4001"},
+			Scheme:        "http",
+			Filter:        "*",
+			WatchInterval: 30,
 		},
 		TemplateConfig: TemplateConfig{
 			ConfDir:     "/etc/confd",
@@ -32,3 +33,23 @@ func TestInitConfigDefaultConfig(t *testing.T) {
 		t.Errorf("initConfig() = %v, want %v", config, want)
 	}
 }
+
+func TestCheckBackendConfigWatchInterval(t *testing.T) {
+	tests := []struct {
+		backend  string
+		interval int
+		ok       bool
+	}{
+		{"http", 30, true},
+		{"http", 0, false},
+		{"vault", -1, false},
+		{"vault", 1, true},
+		{"etcd", 0, true},
+	}
+	for _, tt := range tests {
+		b := BackendsConfig{Backend: tt.backend, BackendNodes: []string{"http://127.0.0.1"}, WatchInterval: tt.interval}
+		if err := checkBackendConfig(b); (err == nil) != tt.ok {
+			t.Errorf("checkBackendConfig(%s, watch interval %d) = %v", tt.backend, tt.interval, err)
+		}
+	}
+}
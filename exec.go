@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelseyhightower/confd/log"
+	"github.com/kelseyhightower/confd/util"
+)
+
+// stopTimeout is how long a child is given to exit after SIGTERM before it
+// is killed, when it is restarted.
+const stopTimeout = 10 * time.Second
+
+// A supervisor runs the command confd was given after its flags, and
+// signals or restarts it when destination files are updated.
+type supervisor struct {
+	args []string
+	// signal is sent to the child on updates. The child is restarted
+	// instead if it is nil.
+	signal  os.Signal
+	changes chan struct{}
+	exited  chan int
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stopping bool
+}
+
+// newSupervisor returns a supervisor running args. The child is sent the
+// signal named sig on updates, or restarted if sig is empty.
+func newSupervisor(args []string, sig string) (*supervisor, error) {
+	s := &supervisor{
+		args:    args,
+		changes: make(chan struct{}, 1),
+		exited:  make(chan int, 1),
+	}
+	if sig != "" {
+		var err error
+		if s.signal, err = util.ParseSignal(sig); err != nil {
+			return nil, fmt.Errorf("invalid exec-reload-signal: %s", err.Error())
+		}
+	}
+	return s, nil
+}
+
+// Exited returns a channel receiving the exit status of the child when it
+// exits on its own or after a forwarded signal. It is nil for a nil
+// supervisor.
+func (s *supervisor) Exited() <-chan int {
+	if s == nil {
+		return nil
+	}
+	return s.exited
+}
+
+// updated is called when dest was updated. Updates arriving while the
+// child is being signalled or restarted are coalesced.
+func (s *supervisor) updated(dest string) {
+	select {
+	case s.changes <- struct{}{}:
+	default:
+	}
+}
+
+// start starts the child and supervises it.
+// It returns an error if the child cannot be started.
+func (s *supervisor) start() error {
+	s.mu.Lock()
+	cmd, err := s.run()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	go s.supervise(cmd)
+	return nil
+}
+
+// run starts a new child. s.mu must be held, so that signals forwarded
+// meanwhile are sent to the new child.
+func (s *supervisor) run() (*exec.Cmd, error) {
+	cmd := exec.Command(s.args[0], s.args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	log.Info("Running " + strings.Join(s.args, " "))
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	s.cmd = cmd
+	return cmd, nil
+}
+
+// supervise waits for cmd to exit, signalling or restarting it on updates.
+func (s *supervisor) supervise(cmd *exec.Cmd) {
+	for {
+		done := make(chan error, 1)
+		go func() {
+			done <- cmd.Wait()
+		}()
+	wait:
+		for {
+			select {
+			case err := <-done:
+				status := exitStatus(err)
+				log.Info(fmt.Sprintf("%s exited with status %d", s.args[0], status))
+				s.exited <- status
+				return
+			case <-s.changes:
+				s.mu.Lock()
+				stopping := s.stopping
+				s.mu.Unlock()
+				if stopping {
+					continue
+				}
+				if s.signal != nil {
+					log.Info(fmt.Sprintf("Sending %v to %s", s.signal, s.args[0]))
+					if err := cmd.Process.Signal(s.signal); err != nil {
+						log.Error(fmt.Sprintf("Cannot signal %s: %s", s.args[0], err.Error()))
+					}
+					continue
+				}
+				log.Info("Restarting " + s.args[0])
+				terminate(cmd.Process)
+				var err error
+				select {
+				case err = <-done:
+				case <-time.After(stopTimeout):
+					log.Warning(fmt.Sprintf("%s did not exit within %s, killing it", s.args[0], stopTimeout))
+					cmd.Process.Kill()
+					err = <-done
+				}
+				// Hold s.mu until the new child is started, so that a signal
+				// forwarded meanwhile either stops the restart or reaches the
+				// new child.
+				s.mu.Lock()
+				if s.stopping {
+					s.mu.Unlock()
+					// confd is exiting, a signal was forwarded meanwhile.
+					s.exited <- exitStatus(err)
+					return
+				}
+				cmd, err = s.run()
+				s.mu.Unlock()
+				if err != nil {
+					log.Error(fmt.Sprintf("Cannot restart %s: %s", s.args[0], err.Error()))
+					s.exited <- 1
+					return
+				}
+				break wait
+			}
+		}
+	}
+}
+
+// forward sends sig to the child. Once a signal stopping the child was
+// forwarded, it is not restarted anymore.
+func (s *supervisor) forward(sig os.Signal, stop bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopping = s.stopping || stop
+	if s.cmd == nil {
+		return
+	}
+	if err := s.cmd.Process.Signal(sig); err != nil {
+		log.Debug(fmt.Sprintf("Cannot forward %v to %s: %s", sig, s.args[0], err.Error()))
+	}
+}
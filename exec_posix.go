@@ -0,0 +1,93 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/kelseyhightower/confd/log"
+)
+
+// forwardedSignals are the signals passed on to the child in exec mode.
+// SIGHUP is not, it reloads the template resources.
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// stopSignals are the forwarded signals after which confd exits with the
+// child.
+var stopSignals = map[os.Signal]bool{syscall.SIGINT: true, syscall.SIGTERM: true, syscall.SIGQUIT: true}
+
+// reaperEnv is set in the environment of confd when it runs as the child
+// of reap.
+const reaperEnv = "CONFD_REAPER_CHILD"
+
+// terminate asks p to exit.
+func terminate(p *os.Process) {
+	p.Signal(syscall.SIGTERM)
+}
+
+// exitStatus returns the exit status of a child that exited with err, or
+// 128 plus the signal number if it was killed by a signal, like shells do.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var e *exec.ExitError
+	if errors.As(err, &e) {
+		if ws, ok := e.Sys().(syscall.WaitStatus); ok {
+			return waitStatus(ws)
+		}
+	}
+	return 1
+}
+
+func waitStatus(ws syscall.WaitStatus) int {
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+// reap runs confd again as its only child, forwarding signals to it, and
+// reaps every process that exits while orphaned below it. This is what an
+// init process does, for confd running as pid 1 in a container: the child
+// confd can wait for its own processes as usual.
+// It returns the exit status of the child.
+func reap() int {
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), reaperEnv+"=1")
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	signalChan := make(chan os.Signal, 10)
+	signal.Notify(signalChan, append(forwardedSignals, syscall.SIGHUP, syscall.SIGCHLD)...)
+	if err := cmd.Start(); err != nil {
+		log.Fatal(err.Error())
+	}
+	for s := range signalChan {
+		if s != syscall.SIGCHLD {
+			cmd.Process.Signal(s)
+			continue
+		}
+		for {
+			var ws syscall.WaitStatus
+			pid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+			if err != nil || pid <= 0 {
+				break
+			}
+			if pid == cmd.Process.Pid {
+				return waitStatus(ws)
+			}
+		}
+	}
+	return 1
+}
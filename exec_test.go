@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/confd/log"
+)
+
+// waitForFile polls path until its contents are want or a few seconds
+// passed.
+func waitForFile(t *testing.T, path, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, _ := ioutil.ReadFile(path)
+		if string(got) == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: got %q, want %q", path, got, want)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	log.SetLevel("warn")
+	dir, err := ioutil.TempDir("", "confd-exec")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	runs := filepath.Join(dir, "runs")
+	hups := filepath.Join(dir, "hups")
+	script := `trap "echo hup >> ` + hups + `" HUP; trap "exit 3" TERM; echo run >> ` + runs + `; while true; do sleep 0.05; done`
+
+	// The child is restarted on updates.
+	s, err := newSupervisor([]string{"/bin/sh", "-c", script}, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := s.start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	waitForFile(t, runs, "run\n")
+	s.updated("/etc/app.conf")
+	waitForFile(t, runs, "run\nrun\n")
+	s.forward(syscall.SIGTERM, true)
+	select {
+	case status := <-s.Exited():
+		if status != 3 {
+			t.Errorf("got exit status %d, want 3", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the child did not exit")
+	}
+
+	// The child is signalled on updates.
+	os.Remove(runs)
+	s, err = newSupervisor([]string{"/bin/sh", "-c", script}, "HUP")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := s.start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	waitForFile(t, runs, "run\n")
+	s.updated("/etc/app.conf")
+	waitForFile(t, hups, "hup\n")
+	s.forward(syscall.SIGKILL, true)
+	if status := <-s.Exited(); status != 128+int(syscall.SIGKILL) {
+		t.Errorf("got exit status %d, want %d", status, 128+int(syscall.SIGKILL))
+	}
+	if got, _ := ioutil.ReadFile(runs); string(got) != "run\n" {
+		t.Errorf("the child was restarted")
+	}
+}
+
+func TestNewSupervisorInvalidSignal(t *testing.T) {
+	_, err := newSupervisor([]string{"true"}, "SIGFOO")
+	if err == nil || !strings.Contains(err.Error(), "SIGFOO") {
+		t.Errorf("got error %v, want an unsupported signal", err)
+	}
+}
+
+func TestSupervisorStopDuringRestart(t *testing.T) {
+	log.SetLevel("warn")
+	// Forward SIGTERM at various times of a restart: the supervisor must
+	// either not restart the child or pass the signal on to the new one.
+	for i := 0; i < 30; i++ {
+		s, err := newSupervisor([]string{"sleep", "10"}, "")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := s.start(); err != nil {
+			t.Fatal(err.Error())
+		}
+		s.updated("/etc/app.conf")
+		time.Sleep(time.Duration(i) * 200 * time.Microsecond)
+		s.forward(syscall.SIGTERM, true)
+		select {
+		case <-s.Exited():
+		case <-time.After(3 * time.Second):
+			s.mu.Lock()
+			s.cmd.Process.Kill()
+			s.mu.Unlock()
+			t.Fatalf("the child did not exit after SIGTERM was forwarded %s into a restart", time.Duration(i)*200*time.Microsecond)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+var stopSignals = map[os.Signal]bool{os.Interrupt: true}
+
+const reaperEnv = "CONFD_REAPER_CHILD"
+
+func terminate(p *os.Process) {
+	p.Kill()
+}
+
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var e *exec.ExitError
+	if errors.As(err, &e) {
+		return e.ExitCode()
+	}
+	return 1
+}
+
+// reap is never called, as confd does not run as pid 1 on windows.
+func reap() int {
+	return 1
+}
@@ -0,0 +1,121 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Fields are attributes attached to a log entry, such as the template
+// resource or backend it is about. The text format leaves them out, the
+// JSON format emits them as separate attributes.
+type Fields map[string]interface{}
+
+// An Entry logs messages with fields attached.
+type Entry struct {
+	entry *log.Entry
+}
+
+// With returns an Entry logging messages with fields attached.
+func With(fields Fields) *Entry {
+	return &Entry{log.WithFields(log.Fields(fields))}
+}
+
+// With returns an Entry logging messages with the fields of e and fields
+// attached.
+func (e *Entry) With(fields Fields) *Entry {
+	return &Entry{e.entry.WithFields(log.Fields(fields))}
+}
+
+// WithError returns an Entry logging messages with err attached, along
+// with the fields of the first error in its chain that has a LogFields
+// method.
+func WithError(err error) *Entry {
+	return With(errorFields(err))
+}
+
+// WithError returns an Entry logging messages with the fields of e and err
+// attached, as WithError does.
+func (e *Entry) WithError(err error) *Entry {
+	return e.With(errorFields(err))
+}
+
+func errorFields(err error) Fields {
+	fields := Fields{"error": err.Error()}
+	var f interface{ LogFields() Fields }
+	if errors.As(err, &f) {
+		for k, v := range f.LogFields() {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// Debug logs a message with severity DEBUG.
+func (e *Entry) Debug(format string, v ...interface{}) {
+	e.entry.Debug(fmt.Sprintf(format, v...))
+}
+
+// Error logs a message with severity ERROR.
+func (e *Entry) Error(format string, v ...interface{}) {
+	e.entry.Error(fmt.Sprintf(format, v...))
+}
+
+// Fatal logs a message with severity ERROR followed by a call to os.Exit().
+func (e *Entry) Fatal(format string, v ...interface{}) {
+	e.entry.Fatal(fmt.Sprintf(format, v...))
+}
+
+// Info logs a message with severity INFO.
+func (e *Entry) Info(format string, v ...interface{}) {
+	e.entry.Info(fmt.Sprintf(format, v...))
+}
+
+// Warning logs a message with severity WARNING.
+func (e *Entry) Warning(format string, v ...interface{}) {
+	e.entry.Warning(fmt.Sprintf(format, v...))
+}
+
+// SetFormat sets the log format. Valid formats are text, the default, and
+// json.
+func SetFormat(format string) error {
+	switch format {
+	case "", "text":
+		log.SetFormatter(&ConfdFormatter{})
+	case "json":
+		log.SetFormatter(&JSONFormatter{})
+	default:
+		return fmt.Errorf("not a valid log format: %q", format)
+	}
+	return nil
+}
+
+// JSONFormatter formats log entries as JSON objects, one per line, with
+// the time, hostname, tag, pid, level, message and the fields of the
+// entry.
+type JSONFormatter struct {
+}
+
+func (j *JSONFormatter) Format(entry *log.Entry) ([]byte, error) {
+	hostname, _ := os.Hostname()
+	data := make(log.Fields, len(entry.Data)+3)
+	for k, v := range entry.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		data[k] = v
+	}
+	data["hostname"] = hostname
+	data["tag"] = tag
+	data["pid"] = os.Getpid()
+	e := *entry
+	e.Data = data
+	f := &log.JSONFormatter{
+		TimestampFormat: time.RFC3339,
+		FieldMap:        log.FieldMap{log.FieldKeyTime: "time", log.FieldKeyMsg: "message"},
+	}
+	return f.Format(&e)
+}
@@ -0,0 +1,71 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type fieldsError struct{}
+
+func (fieldsError) Error() string     { return "failed" }
+func (fieldsError) LogFields() Fields { return Fields{"template": "app.toml"} }
+
+func TestJSONFormat(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if err := SetFormat("json"); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer SetFormat("text")
+
+	err := fmt.Errorf("processing: %w", fieldsError{})
+	WithError(err).With(Fields{"dest": "/etc/app.conf"}).Error("Cannot sync %s", "/etc/app.conf")
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("%s: %q", err.Error(), buf.String())
+	}
+	want := map[string]interface{}{
+		"level":    "error",
+		"message":  "Cannot sync /etc/app.conf",
+		"error":    "processing: failed",
+		"template": "app.toml",
+		"dest":     "/etc/app.conf",
+		"tag":      tag,
+	}
+	for k, v := range want {
+		if record[k] != v {
+			t.Errorf("%s = %v, want %v", k, record[k], v)
+		}
+	}
+	for _, k := range []string{"time", "hostname", "pid"} {
+		if _, ok := record[k]; !ok {
+			t.Errorf("missing %s", k)
+		}
+	}
+}
+
+func TestTextFormatOmitsFields(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	WithError(errors.New("failed")).Error("Cannot sync")
+	if got := buf.String(); !strings.HasSuffix(got, ": ERROR Cannot sync\n") {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestSetFormatInvalid(t *testing.T) {
+	if err := SetFormat("xml"); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -10,6 +10,7 @@ package log
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -30,11 +31,12 @@ func (c *ConfdFormatter) Format(entry *log.Entry) ([]byte, error) {
 var tag string
 
 func init() {
-	tag = os.Args[0]
+	tag = filepath.Base(os.Args[0])
 	log.SetFormatter(&ConfdFormatter{})
 }
 
-// SetTag sets the tag.
+// SetTag sets the tag. It also becomes the identifier of the messages sent
+// to syslog and journald.
 func SetTag(t string) {
 	tag = t
 }
@@ -0,0 +1,103 @@
+package log
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// Mask replaces the values of sensitive keys in output.
+const Mask = "<redacted>"
+
+var (
+	sensitiveMu       sync.RWMutex
+	sensitivePatterns []string
+	sensitivePrefixes = make(map[string]bool)
+)
+
+// SetSensitiveKeys sets the patterns of the keys whose values must not be
+// printed. Patterns containing a slash are matched against the whole key,
+// others against its last element, using the syntax of path.Match. For
+// example both "/app/*/password" and "password" match
+// "/app/database/password".
+// It returns an error if a pattern is malformed.
+func SetSensitiveKeys(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return err
+		}
+	}
+	sensitiveMu.Lock()
+	defer sensitiveMu.Unlock()
+	sensitivePatterns = patterns
+	return nil
+}
+
+// SetSensitivePrefixes sets the prefixes whose values, and the values of
+// all keys below them, must not be printed, replacing the previous ones.
+func SetSensitivePrefixes(prefixes []string) {
+	m := make(map[string]bool, len(prefixes))
+	for _, p := range prefixes {
+		m[strings.TrimSuffix(p, "/")] = true
+	}
+	sensitiveMu.Lock()
+	defer sensitiveMu.Unlock()
+	sensitivePrefixes = m
+}
+
+// MatchesSensitiveKeys reports whether key matches one of the patterns set
+// with SetSensitiveKeys.
+func MatchesSensitiveKeys(key string) bool {
+	sensitiveMu.RLock()
+	defer sensitiveMu.RUnlock()
+	return matchesSensitiveKeys(key)
+}
+
+func matchesSensitiveKeys(key string) bool {
+	for _, p := range sensitivePatterns {
+		name := key
+		if !strings.Contains(p, "/") {
+			name = path.Base(key)
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSensitive reports whether the value of key must not be printed: it
+// matches one of the sensitive key patterns or is below a sensitive prefix.
+func IsSensitive(key string) bool {
+	sensitiveMu.RLock()
+	defer sensitiveMu.RUnlock()
+	if matchesSensitiveKeys(key) {
+		return true
+	}
+	for k := key; ; k = path.Dir(k) {
+		if sensitivePrefixes[k] || (k == "/" && sensitivePrefixes[""]) {
+			return true
+		}
+		if k == "/" || k == "." || k == "" {
+			return false
+		}
+	}
+}
+
+// Redact returns value, or Mask if key is sensitive.
+func Redact(key, value string) string {
+	if IsSensitive(key) {
+		return Mask
+	}
+	return value
+}
+
+// RedactMap returns a copy of vars with the values of sensitive keys
+// masked.
+func RedactMap(vars map[string]string) map[string]string {
+	out := make(map[string]string, len(vars))
+	for k, v := range vars {
+		out[k] = Redact(k, v)
+	}
+	return out
+}
@@ -0,0 +1,40 @@
+package log
+
+import "testing"
+
+func TestIsSensitive(t *testing.T) {
+	if err := SetSensitiveKeys([]string{"password", "/app/*/token"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer SetSensitiveKeys(nil)
+	SetSensitivePrefixes([]string{"/secret/"})
+	defer SetSensitivePrefixes(nil)
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"/app/database/password", true},
+		{"/password", true},
+		{"/app/api/token", true},
+		{"/app/api/v1/token", false},
+		{"/app/database/host", false},
+		{"/secret", true},
+		{"/secret/database/host", true},
+		{"/secrets/database/host", false},
+	}
+	for _, tt := range tests {
+		if got := IsSensitive(tt.key); got != tt.want {
+			t.Errorf("IsSensitive(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+
+	got := RedactMap(map[string]string{"/app/database/password": "p@ss", "/app/database/host": "db"})
+	if got["/app/database/password"] != Mask || got["/app/database/host"] != "db" {
+		t.Errorf("RedactMap() = %v", got)
+	}
+
+	if err := SetSensitiveKeys([]string{"["}); err == nil {
+		t.Error("SetSensitiveKeys() accepted a malformed pattern")
+	}
+}
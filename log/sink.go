@@ -0,0 +1,123 @@
+package log
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/journal"
+	log "github.com/sirupsen/logrus"
+)
+
+// A sink receives log entries in place of stderr.
+type sink interface {
+	send(level log.Level, message string, fields Fields) error
+}
+
+// SetOutput sets where log messages are written. Valid outputs are stderr,
+// the default, syslog, the local syslog daemon, and journald.
+// It returns an error if the output is unknown or not available.
+func SetOutput(output string) error {
+	var s sink
+	switch output {
+	case "", "stderr":
+	case "syslog":
+		w, err := newSyslog()
+		if err != nil {
+			return fmt.Errorf("cannot log to syslog: %s", err.Error())
+		}
+		s = w
+	case "journald":
+		if !journal.Enabled() {
+			return fmt.Errorf("cannot log to journald: not available")
+		}
+		s = journaldSink{}
+	default:
+		return fmt.Errorf("not a valid log output: %q", output)
+	}
+
+	log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	if s == nil {
+		log.SetOutput(os.Stderr)
+		return nil
+	}
+	log.SetOutput(ioutil.Discard)
+	log.AddHook(&sinkHook{s})
+	return nil
+}
+
+// sinkHook passes log entries on to a sink.
+type sinkHook struct {
+	sink sink
+}
+
+func (h *sinkHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *sinkHook) Fire(entry *log.Entry) error {
+	message := entry.Message
+	if f, ok := entry.Logger.Formatter.(*JSONFormatter); ok {
+		// Syslog adds the time, hostname and tag itself, but JSON records
+		// are sent whole so that they can be parsed.
+		b, err := f.Format(entry)
+		if err != nil {
+			return err
+		}
+		message = strings.TrimSuffix(string(b), "\n")
+	}
+	err := h.sink.send(entry.Level, message, Fields(entry.Data))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: cannot write log message: %s: %s\n", tag, err.Error(), message)
+	}
+	return nil
+}
+
+// journaldSink sends log entries to journald, with their fields as journal
+// fields.
+type journaldSink struct{}
+
+func (journaldSink) send(level log.Level, message string, fields Fields) error {
+	vars := map[string]string{"SYSLOG_IDENTIFIER": tag}
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		vars[journalField(k)] = fmt.Sprint(v)
+	}
+	return journal.Send(message, journalPriority(level), vars)
+}
+
+func journalPriority(level log.Level) journal.Priority {
+	switch level {
+	case log.PanicLevel:
+		return journal.PriEmerg
+	case log.FatalLevel:
+		return journal.PriCrit
+	case log.ErrorLevel:
+		return journal.PriErr
+	case log.WarnLevel:
+		return journal.PriWarning
+	case log.InfoLevel:
+		return journal.PriInfo
+	default:
+		return journal.PriDebug
+	}
+}
+
+// journalField returns the journal field name for the log field k:
+// uppercase, with characters other than letters, digits and underscores
+// replaced by underscores. Leading underscores and digits are not allowed.
+func journalField(k string) string {
+	name := []byte(strings.ToUpper(k))
+	for i, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || name[0] == '_' || name[0] >= '0' && name[0] <= '9' {
+		return "F" + string(name)
+	}
+	return string(name)
+}
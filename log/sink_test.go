@@ -0,0 +1,56 @@
+package log
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type fakeSink struct {
+	level   log.Level
+	message string
+	fields  Fields
+}
+
+func (s *fakeSink) send(level log.Level, message string, fields Fields) error {
+	s.level, s.message, s.fields = level, message, fields
+	return nil
+}
+
+func TestSinkHook(t *testing.T) {
+	s := &fakeSink{}
+	log.AddHook(&sinkHook{s})
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+
+	With(Fields{"template": "app.toml"}).Warning("Cannot sync %s", "/etc/app.conf")
+	if s.level != log.WarnLevel {
+		t.Errorf("level = %v, want %v", s.level, log.WarnLevel)
+	}
+	if s.message != "Cannot sync /etc/app.conf" {
+		t.Errorf("message = %q", s.message)
+	}
+	if s.fields["template"] != "app.toml" {
+		t.Errorf("fields = %v", s.fields)
+	}
+}
+
+func TestJournalField(t *testing.T) {
+	tests := map[string]string{
+		"template":     "TEMPLATE",
+		"key_prefix":   "KEY_PREFIX",
+		"reload-group": "RELOAD_GROUP",
+		"_pid":         "F_PID",
+		"2fa":          "F2FA",
+	}
+	for k, want := range tests {
+		if got := journalField(k); got != want {
+			t.Errorf("journalField(%q) = %q, want %q", k, got, want)
+		}
+	}
+}
+
+func TestSetOutputInvalid(t *testing.T) {
+	if err := SetOutput("kafka"); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,70 @@
+//go:build !windows
+// +build !windows
+
+package log
+
+import (
+	"log/syslog"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// syslogSink sends log entries to the local syslog daemon. The syslog
+// writer is opened again when the tag changes, so that messages always
+// carry the current tag.
+type syslogSink struct {
+	network, raddr string
+
+	mu  sync.Mutex
+	tag string
+	w   *syslog.Writer
+}
+
+func newSyslog() (sink, error) {
+	s := &syslogSink{}
+	if _, err := s.writer(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// writer returns the syslog writer for the current tag. s.mu must be held,
+// or s not yet shared.
+func (s *syslogSink) writer() (*syslog.Writer, error) {
+	if s.w != nil && s.tag == tag {
+		return s.w, nil
+	}
+	w, err := syslog.Dial(s.network, s.raddr, syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	if s.w != nil {
+		s.w.Close()
+	}
+	s.tag, s.w = tag, w
+	return w, nil
+}
+
+func (s *syslogSink) send(level log.Level, message string, fields Fields) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, err := s.writer()
+	if err != nil {
+		return err
+	}
+	switch level {
+	case log.PanicLevel:
+		return w.Emerg(message)
+	case log.FatalLevel:
+		return w.Crit(message)
+	case log.ErrorLevel:
+		return w.Err(message)
+	case log.WarnLevel:
+		return w.Warning(message)
+	case log.InfoLevel:
+		return w.Info(message)
+	default:
+		return w.Debug(message)
+	}
+}
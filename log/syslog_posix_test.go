@@ -0,0 +1,58 @@
+//go:build !windows
+// +build !windows
+
+package log
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestDefaultTag(t *testing.T) {
+	if strings.Contains(tag, "/") {
+		t.Errorf("tag = %q, want the program name without its directory", tag)
+	}
+}
+
+func TestSyslogSinkTag(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "log")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("cannot listen on a unix socket: %v", err)
+	}
+	defer conn.Close()
+
+	defer SetTag(tag)
+	SetTag("confd")
+	s := &syslogSink{network: "unixgram", raddr: addr}
+	read := func() string {
+		t.Helper()
+		buf := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	if err := s.send(log.InfoLevel, "first", nil); err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); !strings.Contains(msg, " confd[") {
+		t.Errorf("message = %q, want tag confd", msg)
+	}
+
+	SetTag("app")
+	if err := s.send(log.InfoLevel, "second", nil); err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); !strings.Contains(msg, " app[") {
+		t.Errorf("message = %q, want tag app", msg)
+	}
+}
@@ -0,0 +1,7 @@
+package log
+
+import "errors"
+
+func newSyslog() (sink, error) {
+	return nil, errors.New("not supported on windows")
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/backends/file"
+	"github.com/kelseyhightower/confd/backends/memory"
+	"github.com/kelseyhightower/confd/log"
+	"github.com/kelseyhightower/confd/resource/template"
+)
+
+// render renders the named template resources, or all of them, with the
+// values of the fixture instead of the backends, without syncing them.
+// Keys of named backends are looked up below the backend name in the
+// fixture. It returns the exit status.
+func render(names []string) int {
+	if config.Fixture == "" {
+		log.Error("No fixture given, set one with -fixture")
+		return 1
+	}
+	values, err := file.ReadValues(config.Fixture)
+	if err != nil {
+		log.Error("Cannot read fixture: " + err.Error())
+		return 1
+	}
+
+	config.TemplateConfig.StoreClient = memory.NewMemoryClient(values)
+	config.TemplateConfig.StoreClients = make(map[string]backends.StoreClient)
+	for name := range config.Backends {
+		prefix := "/" + name
+		named := make(map[string]string)
+		for k, v := range values {
+			if strings.HasPrefix(k, prefix+"/") {
+				named[strings.TrimPrefix(k, prefix)] = v
+			}
+		}
+		config.TemplateConfig.StoreClients[name] = memory.NewMemoryClient(named)
+	}
+
+	if err := template.Render(config.TemplateConfig, names, config.Output, os.Stdout); err != nil {
+		log.Error(err.Error())
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,225 @@
+package template
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+	"text/template/parse"
+
+	util "github.com/kelseyhightower/confd/util"
+)
+
+// A renderCache remembers the parsed source template of a template
+// resource and what its destination file was last synced from, so that
+// the template is only parsed when it changes and only rendered when its
+// inputs change. Processors carry it over when they reload a template
+// resource.
+type renderCache struct {
+	tmpl *template.Template
+	// owner is the template resource whose functions tmpl calls.
+	owner *TemplateResource
+	// srcStamp identifies the version of the source template tmpl was
+	// parsed from.
+	srcStamp string
+	// dynamic is set if tmpl calls a function whose result does not only
+	// depend on the values of the keys, so that it is rendered every time.
+	dynamic bool
+	// fingerprint is the digest of the inputs of the last sync.
+	fingerprint string
+	// values holds the key/value pairs of the last sync, and secrets the
+	// values among them, or decoded from them, that must not be printed.
+	values  map[string]string
+	secrets []string
+	// failed is the fingerprint of the inputs of the last config that was
+	// rolled back because its reload command failed, with failedErr the
+	// error it failed with.
+	failed    string
+	failedErr error
+}
+
+// parseSrc returns the parsed source template, parsing it again only if
+// the file changed since it was last parsed.
+func (t *TemplateResource) parseSrc() (*template.Template, error) {
+	c := t.cache
+	stamp, err := fileStamp(t.Src)
+	if err != nil {
+		return nil, err
+	}
+	if c.tmpl == nil || c.srcStamp != stamp {
+		t.logger().Debug("Compiling source template " + t.Src)
+		tmpl, err := template.New(filepath.Base(t.Src)).Funcs(t.funcMap).ParseFiles(t.Src)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to process template %s, %s", t.Src, err)
+		}
+		c.tmpl, c.owner, c.srcStamp, c.dynamic = tmpl, t, stamp, callsAny(tmpl, dynamicFuncs)
+		c.fingerprint = ""
+	}
+	if c.owner != t {
+		// Bind the template to the key/value store of t.
+		tmpl, err := c.tmpl.Clone()
+		if err != nil {
+			return nil, err
+		}
+		c.tmpl, c.owner = tmpl.Funcs(t.funcMap), t
+	}
+	return c.tmpl, nil
+}
+
+// upToDate reports whether the destination file was last synced from the
+// same template resource, source template and values, and was not changed
+// since.
+func (t *TemplateResource) upToDate() bool {
+	fp := t.fingerprint()
+	return fp != "" && fp == t.cache.fingerprint
+}
+
+// synced records the inputs of a successful sync.
+func (t *TemplateResource) synced() {
+	t.cache.fingerprint = t.fingerprint()
+	t.cache.values = t.vars
+	t.cache.secrets = t.secrets
+	t.cache.failed, t.cache.failedErr = "", nil
+}
+
+// rolledBack records the inputs of a config that was rolled back after
+// its reload command failed with err, once the previous destination file
+// was restored.
+func (t *TemplateResource) rolledBack(err error) {
+	t.cache.failed, t.cache.failedErr = t.fingerprint(), err
+}
+
+// failedBefore returns the error the config failed with if it was rolled
+// back and its inputs, including the restored destination file, did not
+// change since, so that the same broken config is not installed and
+// reloaded again on every run. It returns nil otherwise.
+func (t *TemplateResource) failedBefore() error {
+	fp := t.fingerprint()
+	if fp == "" || fp != t.cache.failed {
+		return nil
+	}
+	return fmt.Errorf("%s was rolled back and nothing changed since: %s", t.Dest, t.cache.failedErr)
+}
+
+// changedKeys returns the keys added, changed or removed since the last
+// sync of t, sorted. All keys changed if t was not synced before.
+func (t *TemplateResource) changedKeys() []string {
+	old := t.cache.values
+	var keys []string
+	for k, v := range t.vars {
+		if ov, ok := old[k]; !ok || ov != v {
+			keys = append(keys, k)
+		}
+	}
+	for k := range old {
+		if _, ok := t.vars[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// fingerprint returns a digest of everything the destination file of t
+// depends on, or an empty string if t cannot be cached.
+func (t *TemplateResource) fingerprint() string {
+	c := t.cache
+	if c.tmpl == nil || c.dynamic || t.hash == "" {
+		return ""
+	}
+	src, err := fileStamp(t.Src)
+	if err != nil || src != c.srcStamp {
+		return ""
+	}
+	dest, err := fileStamp(t.Dest)
+	if err != nil && !os.IsNotExist(err) {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n", t.hash, src, t.valuesHash, dest)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashValues returns a digest of the key/value pairs in vars.
+func hashValues(vars map[string]string) string {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%d:%s%d:%s", len(k), k, len(vars[k]), vars[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fileStamp identifies the version of the file at path by its size, mode,
+// owner and modification time, without reading it. Changing the owner does
+// not change the modification time.
+func fileStamp(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	uid, gid := util.FileOwner(fi)
+	return fmt.Sprintf("%d:%s:%d:%d:%d", fi.Size(), fi.Mode(), uid, gid, fi.ModTime().UnixNano()), nil
+}
+
+// callsAny reports whether any template associated with tmpl calls one of
+// the functions in names.
+func callsAny(tmpl *template.Template, names map[string]bool) bool {
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && nodeCallsAny(t.Tree.Root, names) {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeCallsAny(node parse.Node, names map[string]bool) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, c := range n.Nodes {
+			if nodeCallsAny(c, names) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return nodeCallsAny(n.Pipe, names)
+	case *parse.IfNode:
+		return nodeCallsAny(&n.BranchNode, names)
+	case *parse.RangeNode:
+		return nodeCallsAny(&n.BranchNode, names)
+	case *parse.WithNode:
+		return nodeCallsAny(&n.BranchNode, names)
+	case *parse.BranchNode:
+		return nodeCallsAny(n.Pipe, names) || nodeCallsAny(n.List, names) || nodeCallsAny(n.ElseList, names)
+	case *parse.TemplateNode:
+		return nodeCallsAny(n.Pipe, names)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, c := range n.Cmds {
+			if nodeCallsAny(c, names) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, a := range n.Args {
+			if nodeCallsAny(a, names) {
+				return true
+			}
+		}
+	case *parse.IdentifierNode:
+		return names[n.Ident]
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package template
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/confd/log"
+	util "github.com/kelseyhightower/confd/util"
+)
+
+// newCachedResource writes a template resource rendering src from store
+// and loads it.
+func newCachedResource(t *testing.T, confDir, src string, store staticStore) *TemplateResource {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(confDir, "templates", "foo.tmpl"), []byte(src), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	path := filepath.Join(confDir, "conf.d", "foo.toml")
+	err := ioutil.WriteFile(path, []byte(`
+[template]
+src = "foo.tmpl"
+dest = "`+filepath.Join(confDir, "foo.conf")+`"
+keys = ["/foo"]
+`), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tr, err := NewTemplateResource(path, Config{
+		ConfDir:     confDir,
+		ConfigDir:   filepath.Join(confDir, "conf.d"),
+		StoreClient: store,
+		TemplateDir: filepath.Join(confDir, "templates"),
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return tr
+}
+
+func TestRenderCache(t *testing.T) {
+	log.SetLevel("warn")
+	confDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(confDir)
+
+	store := staticStore{"/foo": "bar"}
+	tr := newCachedResource(t, confDir, `foo = {{getv "/foo"}}`, store)
+	if tr.upToDate() {
+		t.Fatal("upToDate() = true before the first run")
+	}
+	if err := tr.process(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !tr.upToDate() {
+		t.Error("upToDate() = false after a run with nothing changed")
+	}
+
+	// A template resource loaded again shares the cache. Writing the same
+	// source template again must not change its modification time.
+	mtime := fileModTime(t, tr.Src)
+	next := newCachedResource(t, confDir, `foo = {{getv "/foo"}}`, store)
+	if err := os.Chtimes(next.Src, mtime, mtime); err != nil {
+		t.Fatal(err.Error())
+	}
+	next.cache = tr.cache
+	if err := next.setVars(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !next.upToDate() {
+		t.Error("upToDate() = false for a reloaded template resource")
+	}
+
+	store["/foo"] = "baz"
+	if err := tr.setVars(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if tr.upToDate() {
+		t.Error("upToDate() = true after a value changed")
+	}
+	if err := tr.process(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := ioutil.WriteFile(tr.Dest, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	if tr.upToDate() {
+		t.Error("upToDate() = true after the destination file changed")
+	}
+	if err := tr.process(); err != nil {
+		t.Fatal(err.Error())
+	}
+	got, _ := ioutil.ReadFile(tr.Dest)
+	if string(got) != "foo = baz" {
+		t.Errorf("dest = %q, want %q", got, "foo = baz")
+	}
+}
+
+func TestRenderCacheDestOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of a file requires root")
+	}
+	log.SetLevel("warn")
+	confDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(confDir)
+
+	tr := newCachedResource(t, confDir, `foo = {{getv "/foo"}}`, staticStore{"/foo": "bar"})
+	if err := tr.process(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := os.Chown(tr.Dest, 1, 1); err != nil {
+		t.Fatal(err.Error())
+	}
+	if tr.upToDate() {
+		t.Error("upToDate() = true after the owner of the destination file changed")
+	}
+	if err := tr.process(); err != nil {
+		t.Fatal(err.Error())
+	}
+	fi, err := util.FileStat(tr.Dest)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if int(fi.Uid) != tr.Uid || int(fi.Gid) != tr.Gid {
+		t.Errorf("owner = %d:%d, want %d:%d", fi.Uid, fi.Gid, tr.Uid, tr.Gid)
+	}
+}
+
+func TestRenderCacheDynamicTemplate(t *testing.T) {
+	log.SetLevel("warn")
+	confDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(confDir)
+
+	tr := newCachedResource(t, confDir, `{{if true}}{{range $x := seq 1 1}}{{getenv "HOME"}}{{end}}{{end}}`, staticStore{})
+	if err := tr.process(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if tr.upToDate() {
+		t.Error("upToDate() = true for a template calling getenv")
+	}
+}
+
+func fileModTime(t *testing.T, path string) time.Time {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return fi.ModTime()
+}
+
+func TestRenderCacheRolledBack(t *testing.T) {
+	log.SetLevel("panic")
+	defer log.SetLevel("warn")
+	confDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(confDir)
+
+	store := staticStore{"/foo": "new"}
+	tr := newCachedResource(t, confDir, `foo = {{getv "/foo"}}`, store)
+	if err := ioutil.WriteFile(tr.Dest, []byte("foo = old"), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	// The reload command only accepts configs containing "old".
+	reloads := filepath.Join(confDir, "reloads")
+	tr.ReloadCmd = "echo reload >> " + reloads + " && grep -q old " + tr.Dest
+	tr.Rollback = true
+	countReloads := func() int {
+		data, _ := ioutil.ReadFile(reloads)
+		return len(data) / len("reload\n")
+	}
+
+	if err := tr.process(); err == nil {
+		t.Fatal("expected the reload to fail")
+	}
+	if n := countReloads(); n != 2 {
+		t.Fatalf("%d reloads, want the failed one and the one after the rollback", n)
+	}
+	if err := tr.process(); err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Errorf("process() = %v, want the rollback reported", err)
+	}
+	if n := countReloads(); n != 2 {
+		t.Errorf("%d reloads, want the config that was rolled back not installed again", n)
+	}
+
+	store["/foo"] = "older"
+	if err := tr.process(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if n := countReloads(); n != 3 {
+		t.Errorf("%d reloads, want a reload once the values changed", n)
+	}
+	got, _ := ioutil.ReadFile(tr.Dest)
+	if string(got) != "foo = older" {
+		t.Errorf("dest = %q, want %q", got, "foo = older")
+	}
+}
@@ -0,0 +1,225 @@
+package template
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kelseyhightower/confd/log"
+	util "github.com/kelseyhightower/confd/util"
+)
+
+// maxOutput is how much of the output of a command is kept for logging and
+// errors.
+const maxOutput = 64 * 1024
+
+// outputDelay is how long the output of a command is still read after it
+// exited, as processes it left running in the background may keep it open.
+const outputDelay = time.Second
+
+// A command is a check or reload command, with its timeout and the
+// environment variables describing the render it runs for.
+// The command is either cmd, run by the shell, or args, run directly.
+// Reload commands can also be a signal sent to the process in pidfile.
+type command struct {
+	cmd     string
+	args    []string
+	signal  string
+	pidfile string
+	timeout time.Duration
+	env     []string
+}
+
+// String returns cmd, the quoted args, or the signal and pidfile.
+func (c command) String() string {
+	if c.signal != "" {
+		return fmt.Sprintf("send %s to the process in %s", c.signal, c.pidfile)
+	}
+	if len(c.args) == 0 {
+		return c.cmd
+	}
+	quoted := make([]string, len(c.args))
+	for i, arg := range c.args {
+		quoted[i] = strconv.Quote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// hasCheck reports whether t has a check command.
+func (t *TemplateResource) hasCheck() bool {
+	return t.CheckCmd != "" || len(t.CheckArgs) > 0
+}
+
+// hasReload reports whether t has a reload command.
+func (t *TemplateResource) hasReload() bool {
+	return t.ReloadCmd != "" || len(t.ReloadArgs) > 0 || t.ReloadSignal != ""
+}
+
+// checkCommand returns the check command of t.
+func (t *TemplateResource) checkCommand() command {
+	return command{cmd: t.CheckCmd, args: t.CheckArgs, timeout: t.CheckTimeout, env: t.commandEnv()}
+}
+
+// reloadCommand returns the reload command of t.
+func (t *TemplateResource) reloadCommand() command {
+	return command{
+		cmd:     t.ReloadCmd,
+		args:    t.ReloadArgs,
+		signal:  t.ReloadSignal,
+		pidfile: t.Pidfile,
+		timeout: t.ReloadTimeout,
+		env:     t.commandEnv(),
+	}
+}
+
+// commandEnv returns the environment variables describing the render of t.
+func (t *TemplateResource) commandEnv() []string {
+	env := []string{
+		"CONFD_TEMPLATE=" + t.name,
+		"CONFD_DEST=" + t.Dest,
+		"CONFD_CHANGED_KEYS=" + strings.Join(t.changedKeys(), "\n"),
+	}
+	if t.StageFile != nil {
+		env = append(env, "CONFD_STAGED="+t.StageFile.Name())
+	}
+	return env
+}
+
+// runCommand is a shared function used by check and reload
+// to run the given command and log its output.
+// It returns nil if the given cmd returns 0, or an error including the
+// output of the command otherwise. A command running longer than its
+// timeout is killed, along with the processes it started. Processes that
+// left its process group, such as daemons, are not waited for.
+// The command can be run on unix and windows.
+func runCommand(cmd command) error {
+	if cmd.signal != "" {
+		return signalProcess(cmd.signal, cmd.pidfile)
+	}
+	logger := log.With(log.Fields{"command": cmd.String()})
+	logger.Debug("Running " + cmd.String())
+	var c *exec.Cmd
+	if len(cmd.args) > 0 {
+		c = exec.Command(cmd.args[0], cmd.args[1:]...)
+	} else if runtime.GOOS == "windows" {
+		c = exec.Command("cmd", "/C", cmd.cmd)
+	} else {
+		c = exec.Command("/bin/sh", "-c", cmd.cmd)
+	}
+	c.Env = append(os.Environ(), cmd.env...)
+	// With a pipe rather than a buffer, Wait does not wait for the output
+	// to be closed by every process holding it.
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	c.Stdout = w
+	c.Stderr = w
+	setProcessGroup(c)
+
+	err = c.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+	output := &limitedBuffer{}
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(output, r)
+		close(copied)
+	}()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Wait()
+	}()
+	var timeout <-chan time.Time
+	if cmd.timeout > 0 {
+		timer := time.NewTimer(cmd.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case err = <-done:
+	case <-timeout:
+		if kerr := killProcessGroup(c); kerr != nil {
+			logger.WithError(kerr).Warning("Cannot kill " + cmd.String())
+		}
+		<-done
+		err = fmt.Errorf("timed out after %s", cmd.timeout)
+	}
+	select {
+	case <-copied:
+	case <-time.After(outputDelay):
+		r.Close()
+		<-copied
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %q", err, output.String())
+	}
+	logger.Debug(fmt.Sprintf("%q", output.String()))
+	return nil
+}
+
+// signalProcess sends the signal named sig to the process whose pid is in
+// pidfile, after checking that the process exists.
+// It returns an error if any.
+func signalProcess(sig, pidfile string) error {
+	s, err := util.ParseSignal(sig)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(pidfile)
+	if err != nil {
+		return fmt.Errorf("cannot read pidfile: %s", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("invalid pidfile %s: %q is not a pid", pidfile, strings.TrimSpace(string(data)))
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if err := p.Signal(syscall.Signal(0)); err != nil {
+		if errors.Is(err, os.ErrProcessDone) {
+			return fmt.Errorf("stale pidfile %s: process %d does not exist", pidfile, pid)
+		}
+		return fmt.Errorf("cannot signal process %d from pidfile %s: %s", pid, pidfile, err)
+	}
+	log.With(log.Fields{"pid": pid, "pidfile": pidfile}).Debug(fmt.Sprintf("Sending %s to process %d", sig, pid))
+	return p.Signal(s)
+}
+
+// limitedBuffer keeps the first maxOutput bytes written to it.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if left := maxOutput - b.buf.Len(); n > left {
+		p = p[:left]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "... (truncated)"
+	}
+	return b.buf.String()
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package template
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes c run in a process group of its own, so that the
+// processes it starts can be killed along with it.
+func setProcessGroup(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the process group of c.
+func killProcessGroup(c *exec.Cmd) error {
+	return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+}
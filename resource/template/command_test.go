@@ -0,0 +1,186 @@
+package template
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/confd/log"
+)
+
+func TestRunCommandTimeout(t *testing.T) {
+	log.SetLevel("warn")
+	start := time.Now()
+	// The background sleep keeps the output open unless the whole process
+	// group is killed.
+	err := runCommand(command{cmd: "echo started; sleep 10 & sleep 10", timeout: 200 * time.Millisecond})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("command ran for %s", elapsed)
+	}
+	if want := `timed out after 200ms: "started\n"`; err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+}
+
+func TestRunCommandDetachedChild(t *testing.T) {
+	log.SetLevel("warn")
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("setsid is not available")
+	}
+	// setsid moves sleep out of the process group of the command, so it is
+	// not killed and keeps the output open.
+	start := time.Now()
+	err := runCommand(command{cmd: "echo started; setsid sleep 10 & sleep 10", timeout: 200 * time.Millisecond})
+	if want := `timed out after 200ms: "started\n"`; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("command ran for %s", elapsed)
+	}
+
+	start = time.Now()
+	if err := runCommand(command{cmd: "echo started; setsid sleep 10 &"}); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("command ran for %s", elapsed)
+	}
+}
+
+func TestRunCommandOutput(t *testing.T) {
+	log.SetLevel("warn")
+	err := runCommand(command{cmd: "echo out; echo err >&2; exit 3"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if want := `exit status 3: "out\nerr\n"`; err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+
+	err = runCommand(command{cmd: "head -c 100000 /dev/zero; exit 1"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.HasSuffix(err.Error(), `... (truncated)"`) || len(err.Error()) > 5*maxOutput {
+		t.Errorf("output not truncated: %d bytes", len(err.Error()))
+	}
+}
+
+func TestCommandEnv(t *testing.T) {
+	log.SetLevel("warn")
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+	env := filepath.Join(tempConfDir, "env")
+	tr := &TemplateResource{
+		Dest:      "/etc/app.conf",
+		ReloadCmd: `printf '%s|%s|%s' "$CONFD_TEMPLATE" "$CONFD_DEST" "$CONFD_CHANGED_KEYS" > ` + env,
+		name:      "app.toml",
+		cache:     &renderCache{values: map[string]string{"/a": "1", "/b": "2", "/c": "3"}},
+		vars:      map[string]string{"/a": "1", "/b": "changed", "/d": "4"},
+	}
+	if err := tr.reload(); err != nil {
+		t.Fatal(err.Error())
+	}
+	got, err := ioutil.ReadFile(env)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	want := "app.toml|/etc/app.conf|/b\n/c\n/d"
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCheckArgs(t *testing.T) {
+	log.SetLevel("warn")
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+	// The name would break a shell command, or run touch.
+	staged, err := os.Create(filepath.Join(tempConfDir, "a b; touch $(echo pwned)"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer staged.Close()
+	staged.WriteString("checked\n")
+	out := filepath.Join(tempConfDir, "out")
+	tr := &TemplateResource{
+		CheckArgs: []string{"cp", "{{.src}}", out},
+		StageFile: staged,
+		cache:     &renderCache{},
+	}
+	if err := tr.check(); err != nil {
+		t.Fatal(err.Error())
+	}
+	got, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(got) != "checked\n" {
+		t.Errorf("got %q, want %q", got, "checked\n")
+	}
+	if _, err := os.Stat("pwned"); err == nil {
+		os.Remove("pwned")
+		t.Error("the check command ran through a shell")
+	}
+}
+
+func TestReloadSignal(t *testing.T) {
+	log.SetLevel("warn")
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+	out := filepath.Join(tempConfDir, "out")
+	pidfile := filepath.Join(tempConfDir, "app.pid")
+
+	ready := filepath.Join(tempConfDir, "ready")
+	c := exec.Command("/bin/sh", "-c", `trap "echo reloaded > `+out+`; exit 0" HUP; touch `+ready+`; while true; do sleep 0.05; done`)
+	if err := c.Start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	waitFor(t, "the process to trap SIGHUP", func() bool {
+		_, err := os.Stat(ready)
+		return err == nil
+	})
+	if err := ioutil.WriteFile(pidfile, []byte(strconv.Itoa(c.Process.Pid)+"\n"), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	tr := &TemplateResource{ReloadSignal: "HUP", Pidfile: pidfile, cache: &renderCache{}}
+	if err := tr.reload(); err != nil {
+		c.Process.Kill()
+		t.Fatal(err.Error())
+	}
+	if err := c.Wait(); err != nil {
+		t.Fatal(err.Error())
+	}
+	got, _ := ioutil.ReadFile(out)
+	if string(got) != "reloaded\n" {
+		t.Errorf("got %q, want %q", got, "reloaded\n")
+	}
+
+	// The process is gone now.
+	err = tr.reload()
+	if want := fmt.Sprintf("stale pidfile %s: process %d does not exist", pidfile, c.Process.Pid); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	ioutil.WriteFile(pidfile, []byte("nginx\n"), 0644)
+	if err := tr.reload(); err == nil {
+		t.Error("expected an error for an invalid pidfile")
+	}
+}
@@ -0,0 +1,12 @@
+package template
+
+import (
+	"os/exec"
+)
+
+func setProcessGroup(c *exec.Cmd) {
+}
+
+func killProcessGroup(c *exec.Cmd) error {
+	return c.Process.Kill()
+}
@@ -0,0 +1,189 @@
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/kelseyhightower/confd/log"
+	util "github.com/kelseyhightower/confd/util"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// diffOutput is where the changes of noop mode and -diff are printed.
+var diffOutput io.Writer = os.Stdout
+
+// printDiff prints the changes syncing t would make to its destination
+// file: the owner and mode, and a unified diff of the contents.
+func (t *TemplateResource) printDiff() error {
+	d, err := t.diff()
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(diffOutput, d)
+	return err
+}
+
+// diff returns the changes between the destination file of t and its
+// staged file.
+func (t *TemplateResource) diff() (string, error) {
+	staged := t.StageFile.Name()
+	to, err := ioutil.ReadFile(staged)
+	if err != nil {
+		return "", err
+	}
+	s, err := util.FileStat(staged)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	var from []byte
+	fromFile := t.Dest
+	if util.IsFileExist(t.Dest) {
+		if from, err = ioutil.ReadFile(t.Dest); err != nil {
+			return "", err
+		}
+		d, err := util.FileStat(t.Dest)
+		if err != nil {
+			return "", err
+		}
+		if d.Uid != s.Uid || d.Gid != s.Gid {
+			fmt.Fprintf(&buf, "owner %s: %d:%d => %d:%d\n", t.Dest, d.Uid, d.Gid, s.Uid, s.Gid)
+		}
+		if d.Mode != s.Mode {
+			fmt.Fprintf(&buf, "mode %s: %s => %s\n", t.Dest, d.Mode, s.Mode)
+		}
+	} else {
+		fromFile = "/dev/null"
+		fmt.Fprintf(&buf, "new file %s: owner %d:%d, mode %s\n", t.Dest, s.Uid, s.Gid, s.Mode)
+	}
+
+	if bytes.Equal(from, to) {
+		return buf.String(), nil
+	}
+	if t.Sensitive {
+		fmt.Fprintf(&buf, "contents of %s differ, not shown as the template resource is sensitive\n", t.Dest)
+		return buf.String(), nil
+	}
+	from, to = t.redact(from), t.redact(to)
+	if bytes.Equal(from, to) {
+		fmt.Fprintf(&buf, "contents of %s differ in redacted values\n", t.Dest)
+	} else {
+		ud, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        splitLines(from),
+			B:        splitLines(to),
+			FromFile: fromFile,
+			ToFile:   t.Dest,
+			Context:  3,
+		})
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(ud)
+	}
+	return buf.String(), nil
+}
+
+// redact masks the values of the sensitive keys of t in b, both the
+// current ones and those of the last sync, which the destination file
+// may still hold.
+func (t *TemplateResource) redact(b []byte) []byte {
+	var secrets []string
+	for _, v := range t.secrets {
+		if v != "" {
+			secrets = append(secrets, v)
+		}
+	}
+	if t.cache != nil {
+		for _, v := range t.cache.secrets {
+			if v != "" {
+				secrets = append(secrets, v)
+			}
+		}
+	}
+	// Mask longer values first, in case one contains another.
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	for _, v := range secrets {
+		b = bytes.ReplaceAll(b, []byte(v), []byte(log.Mask))
+	}
+	return b
+}
+
+// isSecret reports whether v is the value of a sensitive key of t, or was
+// decoded from one.
+func (t *TemplateResource) isSecret(v string) bool {
+	for _, s := range t.secrets {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// addSecrets records the strings in v, decoded from a secret, as secrets
+// of t.
+func (t *TemplateResource) addSecrets(v interface{}) {
+	switch v := v.(type) {
+	case string:
+		t.secrets = append(t.secrets, v)
+	case map[string]interface{}:
+		for _, e := range v {
+			t.addSecrets(e)
+		}
+	case []interface{}:
+		for _, e := range v {
+			t.addSecrets(e)
+		}
+	}
+}
+
+// decrypt decrypts data with the keyring of t, for the cget functions.
+func (t *TemplateResource) decrypt(data string) (string, error) {
+	v, err := Decrypt(t.keyring, data)
+	if err == nil && t.isSecret(data) {
+		t.addSecrets(v)
+	}
+	return v, err
+}
+
+// addRedactFuncs replaces the functions of funcMap decoding values with
+// ones recording the values they decode from secrets, so that they are
+// redacted too.
+func (t *TemplateResource) addRedactFuncs(funcMap map[string]interface{}) {
+	funcMap["base64Decode"] = func(data string) (string, error) {
+		v, err := Base64Decode(data)
+		if err == nil && t.isSecret(data) {
+			t.addSecrets(v)
+		}
+		return v, err
+	}
+	funcMap["json"] = func(data string) (map[string]interface{}, error) {
+		v, err := UnmarshalJsonObject(data)
+		if err == nil && t.isSecret(data) {
+			t.addSecrets(v)
+		}
+		return v, err
+	}
+	funcMap["jsonArray"] = func(data string) ([]interface{}, error) {
+		v, err := UnmarshalJsonArray(data)
+		if err == nil && t.isSecret(data) {
+			t.addSecrets(v)
+		}
+		return v, err
+	}
+}
+
+// splitLines splits b into lines, each ending in a newline.
+func splitLines(b []byte) []string {
+	lines := strings.SplitAfter(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n"
+	return lines
+}
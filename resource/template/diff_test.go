@@ -0,0 +1,162 @@
+package template
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/kelseyhightower/confd/log"
+)
+
+func TestDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	dest := filepath.Join(dir, "foo.conf")
+	if err := ioutil.WriteFile(dest, []byte("a\nb\nc\n"), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	staged := filepath.Join(dir, ".foo.conf")
+	if err := ioutil.WriteFile(staged, []byte("a\nB\nc"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	os.Chmod(staged, 0600)
+	f, err := os.Open(staged)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer f.Close()
+
+	tr := &TemplateResource{Dest: dest, StageFile: f}
+	got, err := tr.diff()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	want := "mode " + dest + ": -rw-r--r-- => -rw-------\n" +
+		"--- " + dest + "\n" +
+		"+++ " + dest + "\n" +
+		"@@ -1,3 +1,3 @@\n" +
+		" a\n" +
+		"-b\n" +
+		"+B\n" +
+		" c\n"
+	if got != want {
+		t.Errorf("diff() = %q, want %q", got, want)
+	}
+
+	os.Remove(dest)
+	got, err = tr.diff()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	want = "new file " + dest + ": owner " + strconv.Itoa(os.Getuid()) + ":" + strconv.Itoa(os.Getgid()) + ", mode -rw-------\n" +
+		"--- /dev/null\n" +
+		"+++ " + dest + "\n" +
+		"@@ -0,0 +1,3 @@\n" +
+		"+a\n" +
+		"+B\n" +
+		"+c\n"
+	if got != want {
+		t.Errorf("diff() = %q, want %q", got, want)
+	}
+}
+
+func TestDiffRedactsSecrets(t *testing.T) {
+	log.SetLevel("warn")
+	if err := log.SetSensitiveKeys([]string{"password"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer log.SetSensitiveKeys(nil)
+	confDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(confDir)
+
+	store := staticStore{"/foo/user": "rob", "/foo/password": "old"}
+	tr := newCachedResource(t, confDir, "user = {{getv \"/foo/user\"}}\npassword = {{getv \"/foo/password\"}}\n", store)
+	if err := tr.process(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// The password was rotated: the old one must not show either.
+	store["/foo/user"], store["/foo/password"] = "bob", "s3cret"
+	if err := tr.setVars(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := tr.createStageFile(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(tr.StageFile.Name())
+	got, err := tr.diff()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	want := "--- " + tr.Dest + "\n" +
+		"+++ " + tr.Dest + "\n" +
+		"@@ -1,2 +1,2 @@\n" +
+		"-user = rob\n" +
+		"+user = bob\n" +
+		" password = <redacted>\n"
+	if got != want {
+		t.Errorf("diff() = %q, want %q", got, want)
+	}
+
+	tr.Sensitive = true
+	got, err = tr.diff()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	want = "contents of " + tr.Dest + " differ, not shown as the template resource is sensitive\n"
+	if got != want {
+		t.Errorf("diff() = %q, want %q", got, want)
+	}
+}
+
+func TestRedactDecodedSecrets(t *testing.T) {
+	keyring, err := readKeyring("testdata/secring.asc")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tr := &TemplateResource{keyring: keyring, secrets: []string{encryptedAbc, "czNjcmV0", `{"token": "t0k", "port": 80}`}}
+	funcMap := newFuncMap()
+	tr.addRedactFuncs(funcMap)
+
+	if _, err := tr.decrypt(encryptedAbc); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, data := range []string{"czNjcmV0", "cHVibGlj"} {
+		if _, err := funcMap["base64Decode"].(func(string) (string, error))(data); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if _, err := funcMap["json"].(func(string) (map[string]interface{}, error))(`{"token": "t0k", "port": 80}`); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	got := string(tr.redact([]byte("abc s3cret t0k public 80")))
+	if want := "<redacted> <redacted> <redacted> public 80"; got != want {
+		t.Errorf("redact() = %q, want %q", got, want)
+	}
+}
+
+func TestSetSensitivePrefixes(t *testing.T) {
+	defer log.SetSensitivePrefixes(nil)
+	secret := &TemplateResource{Sensitive: true, stores: []*storeBinding{{keys: []string{"/credentials"}}}}
+	plain := &TemplateResource{stores: []*storeBinding{{keys: []string{"/app"}}}}
+
+	setSensitivePrefixes([]*TemplateResource{secret, plain})
+	if !log.IsSensitive("/credentials/password") || log.IsSensitive("/app/host") {
+		t.Error("only the keys of the sensitive template resource must be sensitive")
+	}
+	// The sensitive template resource was removed.
+	setSensitivePrefixes([]*TemplateResource{plain})
+	if log.IsSensitive("/credentials/password") {
+		t.Error("the keys of a removed template resource are still sensitive")
+	}
+}
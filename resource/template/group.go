@@ -0,0 +1,114 @@
+package template
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/kelseyhightower/confd/log"
+)
+
+// reloadGroups defers the reload commands of template resources sharing a
+// reload_group until every member being processed has been synced, and
+// then runs each distinct reload command of the group once.
+type reloadGroups struct {
+	mu     sync.Mutex
+	groups map[string]*reloadGroup
+}
+
+type reloadGroup struct {
+	// active is the number of members being processed.
+	active int
+	// pending holds the reload commands of the synced members, in order.
+	pending []*pendingReload
+}
+
+// A pendingReload is a deferred reload command and the backups of the
+// destination files of members to restore if it fails.
+type pendingReload struct {
+	cmd     command
+	backups []*backup
+	members []*TemplateResource
+}
+
+func newReloadGroups() *reloadGroups {
+	return &reloadGroups{groups: make(map[string]*reloadGroup)}
+}
+
+// begin marks the template resources in ts as being processed. Each of
+// them must be processed afterwards.
+func (r *reloadGroups) begin(ts []*TemplateResource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range ts {
+		t.reloads = r
+		// Transactions reload their members themselves.
+		if t.ReloadGroup == "" || t.Transaction != "" {
+			continue
+		}
+		g, ok := r.groups[t.ReloadGroup]
+		if !ok {
+			g = &reloadGroup{}
+			r.groups[t.ReloadGroup] = g
+		}
+		g.active++
+	}
+}
+
+// deferReload records that the reload command of t must run once its
+// group is done. b is the backup of the destination file of t, if any.
+func (r *reloadGroups) deferReload(t *TemplateResource, b *backup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	g := r.groups[t.ReloadGroup]
+	cmd := t.reloadCommand()
+	var p *pendingReload
+	for _, pr := range g.pending {
+		if pr.cmd.String() == cmd.String() {
+			p = pr
+		}
+	}
+	if p == nil {
+		p = &pendingReload{cmd: cmd}
+		g.pending = append(g.pending, p)
+	}
+	if b != nil {
+		p.backups = append(p.backups, b)
+		p.members = append(p.members, t)
+	}
+}
+
+// done marks t as processed. If t is the last member of its group being
+// processed, the deferred reload commands of the group are run.
+func (r *reloadGroups) done(t *TemplateResource) error {
+	if t.ReloadGroup == "" {
+		return nil
+	}
+	r.mu.Lock()
+	g := r.groups[t.ReloadGroup]
+	g.active--
+	if g.active > 0 {
+		r.mu.Unlock()
+		return nil
+	}
+	pending := g.pending
+	delete(r.groups, t.ReloadGroup)
+	r.mu.Unlock()
+
+	var lastErr error
+	logger := log.With(log.Fields{"reload_group": t.ReloadGroup})
+	for _, p := range pending {
+		logger.Info(fmt.Sprintf("Reloading template resources of group %s", t.ReloadGroup))
+		if err := runCommand(p.cmd); err != nil {
+			commandFailures.WithLabelValues(t.name, "reload").Inc()
+			if len(p.backups) > 0 {
+				err = rollback([]command{p.cmd}, p.backups, err)
+				for _, m := range p.members {
+					m.rolledBack(err)
+				}
+			}
+			lastErr = fmt.Errorf("reload of group %s failed: %s", t.ReloadGroup, err.Error())
+			logger.WithError(err).Error(lastErr.Error())
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,99 @@
+package template
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are labelled with the name of the template resource, its path
+// relative to conf.d, and the name of the backend, default for the unnamed
+// backend.
+var (
+	backendGetValuesDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "confd",
+		Name:      "backend_get_values_duration_seconds",
+		Help:      "Time taken to retrieve the keys of a template resource from a backend.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend"})
+
+	backendGetValuesErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "confd",
+		Name:      "backend_get_values_errors_total",
+		Help:      "Number of failed retrievals of keys from a backend.",
+	}, []string{"backend"})
+
+	backendWatchWakeups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "confd",
+		Name:      "backend_watch_wakeups_total",
+		Help:      "Number of times watching a backend reported a change.",
+	}, []string{"backend"})
+
+	backendWatchErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "confd",
+		Name:      "backend_watch_errors_total",
+		Help:      "Number of failed watches of a backend.",
+	}, []string{"backend"})
+
+	templateRenderDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "confd",
+		Name:      "template_render_duration_seconds",
+		Help:      "Time taken to execute the source template of a template resource.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"template"})
+
+	templateFiles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "confd",
+		Name:      "template_files_total",
+		Help:      "Number of rendered files that changed or did not change the destination file, or could not be compared to it.",
+	}, []string{"template", "result"})
+
+	commandFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "confd",
+		Name:      "command_failures_total",
+		Help:      "Number of failed check and reload commands.",
+	}, []string{"template", "command"})
+
+	templateLastSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "confd",
+		Name:      "template_last_sync_timestamp_seconds",
+		Help:      "Unix time of the last successful sync of a template resource.",
+	}, []string{"template"})
+)
+
+var (
+	loadedMu sync.Mutex
+	// loaded holds the names of the template resources loaded last.
+	loaded = make(map[string]bool)
+)
+
+// setLoadedTemplates deletes the last sync time of the template resources
+// loaded before that are not in ts anymore, so that removed template
+// resources do not look like they stopped syncing.
+func setLoadedTemplates(ts []*TemplateResource) {
+	names := make(map[string]bool, len(ts))
+	for _, t := range ts {
+		names[t.name] = true
+	}
+	loadedMu.Lock()
+	defer loadedMu.Unlock()
+	for name := range loaded {
+		if !names[name] {
+			templateLastSync.DeleteLabelValues(name)
+		}
+	}
+	loaded = names
+}
+
+func init() {
+	prometheus.MustRegister(
+		backendGetValuesDuration,
+		backendGetValuesErrors,
+		backendWatchWakeups,
+		backendWatchErrors,
+		templateRenderDuration,
+		templateFiles,
+		commandFailures,
+		templateLastSync,
+	)
+}
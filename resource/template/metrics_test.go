@@ -0,0 +1,19 @@
+package template
+
+import "testing"
+
+func TestSetLoadedTemplatesDeletesLastSync(t *testing.T) {
+	a, b := &TemplateResource{name: "a.toml"}, &TemplateResource{name: "b.toml"}
+	setLoadedTemplates([]*TemplateResource{a, b})
+	templateLastSync.WithLabelValues("a.toml").SetToCurrentTime()
+	templateLastSync.WithLabelValues("b.toml").SetToCurrentTime()
+
+	setLoadedTemplates([]*TemplateResource{b})
+	if templateLastSync.DeleteLabelValues("a.toml") {
+		t.Error("last sync time of removed a.toml still exported")
+	}
+	if !templateLastSync.DeleteLabelValues("b.toml") {
+		t.Error("last sync time of b.toml deleted")
+	}
+	setLoadedTemplates(nil)
+}
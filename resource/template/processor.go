@@ -11,6 +11,34 @@ import (
 
 type Processor interface {
 	Process()
+	// Status returns the status of every loaded template resource.
+	Status() []Status
+	// Trigger processes the named template resource, or all of them if
+	// name is empty, without waiting for the next interval or change.
+	Trigger(name string) error
+	// Reload loads the template resources from conf.d again.
+	Reload()
+}
+
+// An Error is an error processing template resources or watching their
+// keys. Its log fields tell which template resource, transaction or
+// backend it is about.
+type Error struct {
+	Err    error
+	Fields log.Fields
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// LogFields returns the fields to log e with.
+func (e *Error) LogFields() log.Fields {
+	return e.Fields
 }
 
 func Process(config Config) error {
@@ -23,9 +51,10 @@ func Process(config Config) error {
 
 func process(ts []*TemplateResource) error {
 	var lastErr error
-	for _, t := range ts {
-		if err := t.process(); err != nil {
-			log.Error(err.Error())
+	newReloadGroups().begin(ts)
+	for _, u := range units(ts) {
+		if err := processUnit(u); err != nil {
+			log.WithError(err).Error(err.Error())
 			lastErr = err
 		}
 	}
@@ -38,41 +67,182 @@ type intervalProcessor struct {
 	doneChan chan bool
 	errChan  chan error
 	interval int
+	status   *statusMap
+	trigger  chan string
+	// caches holds the render cache of each template resource, by name, as
+	// template resources are loaded again on every run.
+	caches map[string]*renderCache
 }
 
 func IntervalProcessor(config Config, stopChan, doneChan chan bool, errChan chan error, interval int) Processor {
-	return &intervalProcessor{config, stopChan, doneChan, errChan, interval}
+	return &intervalProcessor{
+		config:   config,
+		stopChan: stopChan,
+		doneChan: doneChan,
+		errChan:  errChan,
+		interval: interval,
+		status:   newStatusMap(),
+		trigger:  make(chan string, 1),
+	}
 }
 
 func (p *intervalProcessor) Process() {
 	defer close(p.doneChan)
+	w := watchConfig(p.config)
+	defer w.Close()
+	var name string
+	var ts []*TemplateResource
 	for {
-		ts, err := getTemplateResources(p.config)
-		if err != nil {
+		// The running template resources are kept if any of them fails to
+		// load again, as when a file in conf.d is being edited.
+		next, err := getTemplateResources(p.config)
+		switch {
+		case err == nil:
+			ts = next
+		case ts == nil:
 			log.Fatal(err.Error())
-			break
+			return
+		default:
+			log.Error("Cannot reload template resources: " + err.Error())
+		}
+		p.status.track(ts)
+		caches := make(map[string]*renderCache, len(ts))
+		for _, t := range ts {
+			if c, ok := p.caches[t.name]; ok {
+				t.cache = c
+			}
+			caches[t.name] = t.cache
+		}
+		p.caches = caches
+		var run []*TemplateResource
+		var runUnits [][]*TemplateResource
+		for _, u := range units(ts) {
+			for _, t := range u {
+				if name == "" || t.name == name {
+					run = append(run, u...)
+					runUnits = append(runUnits, u)
+					break
+				}
+			}
+		}
+		newReloadGroups().begin(run)
+		for _, u := range runUnits {
+			if err := p.status.process(u); err != nil {
+				log.WithError(err).Error(err.Error())
+			}
 		}
-		process(ts)
 		select {
 		case <-p.stopChan:
-			break
+			return
+		case name = <-p.trigger:
+			continue
+		case <-w.Changes():
+			log.Info("Reloading template resources")
+			name = ""
+			continue
 		case <-time.After(time.Duration(p.interval) * time.Second):
+			name = ""
 			continue
 		}
 	}
 }
 
+func (p *intervalProcessor) Status() []Status {
+	return p.status.list()
+}
+
+func (p *intervalProcessor) Trigger(name string) error {
+	if name != "" && !p.status.has(name) {
+		return ErrUnknownTemplate
+	}
+	select {
+	case p.trigger <- name:
+	default:
+	}
+	return nil
+}
+
+// Reload processes all template resources, which are loaded from conf.d
+// on every run.
+func (p *intervalProcessor) Reload() {
+	p.Trigger("")
+}
+
 type watchProcessor struct {
 	config   Config
 	stopChan chan bool
 	doneChan chan bool
 	errChan  chan error
-	wg       sync.WaitGroup
+	status   *statusMap
+	reload   chan struct{}
+	reloads  *reloadGroups
+
+	// monitors holds the monitor of each template resource, by name.
+	mu       sync.Mutex
+	monitors map[string]*monitor
+	// transactions holds the members of each transaction and groups the
+	// members of each reload group. They have a lock of their own as
+	// update holds mu while it waits for monitors, which call unit, to
+	// stop.
+	unitsMu      sync.Mutex
+	transactions map[string][]*TemplateResource
+	groups       map[string][]*TemplateResource
+	// txMu serializes processing transactions and reload groups, which any
+	// of their members can start.
+	txMu sync.Mutex
+}
+
+// A monitor processes a template resource whenever its keys change, until
+// it is stopped.
+type monitor struct {
+	t *TemplateResource
+	// resourceHash and srcHash are the checksums of the template resource
+	// file and of its source template, to tell which changed on reload.
+	resourceHash string
+	srcHash      string
+	changed      chan struct{}
+	stopChan     chan bool
+	done         chan struct{}
+}
+
+func newMonitor(t *TemplateResource) *monitor {
+	return &monitor{
+		t:            t,
+		resourceHash: t.hash,
+		srcHash:      fileHash(t.Src),
+		changed:      make(chan struct{}, 1),
+		stopChan:     make(chan bool),
+		done:         make(chan struct{}),
+	}
+}
+
+// stop stops m and waits for it to finish processing.
+func (m *monitor) stop() {
+	close(m.stopChan)
+	<-m.done
+}
+
+// fileHash returns the MD5 checksum of the file at path, or an empty string
+// if it cannot be read.
+func fileHash(path string) string {
+	fi, err := util.FileStat(path)
+	if err != nil {
+		return ""
+	}
+	return fi.Md5
 }
 
 func WatchProcessor(config Config, stopChan, doneChan chan bool, errChan chan error) Processor {
-	var wg sync.WaitGroup
-	return &watchProcessor{config, stopChan, doneChan, errChan, wg}
+	return &watchProcessor{
+		config:   config,
+		stopChan: stopChan,
+		doneChan: doneChan,
+		errChan:  errChan,
+		status:   newStatusMap(),
+		reload:   make(chan struct{}, 1),
+		reloads:  newReloadGroups(),
+		monitors: make(map[string]*monitor),
+	}
 }
 
 func (p *watchProcessor) Process() {
@@ -82,29 +252,230 @@ func (p *watchProcessor) Process() {
 		log.Fatal(err.Error())
 		return
 	}
+	w := watchConfig(p.config)
+	defer w.Close()
+	p.update(ts)
+	for {
+		select {
+		case <-p.stopChan:
+			p.update(nil)
+			return
+		case <-w.Changes():
+			p.load()
+		case <-p.reload:
+			p.load()
+		}
+	}
+}
+
+// load reloads the template resources from conf.d. The running template
+// resources are kept if any of them fails to load.
+func (p *watchProcessor) load() {
+	log.Info("Reloading template resources")
+	ts, err := getTemplateResources(p.config)
+	if err != nil {
+		log.Error("Cannot reload template resources: " + err.Error())
+		return
+	}
+	p.update(ts)
+}
+
+// update starts monitoring the template resources in ts, restarts those
+// whose template resource file changed and stops the others. Template
+// resources whose source template changed are processed again.
+func (p *watchProcessor) update(ts []*TemplateResource) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	next := make(map[string]*monitor, len(ts))
 	for _, t := range ts {
-		t := t
-		p.wg.Add(1)
-		go p.monitorPrefix(t)
+		next[t.name] = newMonitor(t)
+	}
+	for name, m := range p.monitors {
+		n, ok := next[name]
+		if ok && n.resourceHash == m.resourceHash {
+			if n.srcHash != m.srcHash {
+				log.Info("Source template " + m.t.Src + " changed")
+				m.srcHash = n.srcHash
+				signal(m.changed)
+			}
+			continue
+		}
+		if ok {
+			log.Info("Restarting changed template resource " + name)
+		} else {
+			log.Info("Stopping removed template resource " + name)
+		}
+		m.stop()
+		delete(p.monitors, name)
+	}
+	p.status.track(ts)
+	var started []*monitor
+	for name, m := range next {
+		if _, ok := p.monitors[name]; ok {
+			continue
+		}
+		p.monitors[name] = m
+		started = append(started, m)
+	}
+	transactions := make(map[string][]*TemplateResource)
+	groups := make(map[string][]*TemplateResource)
+	for _, m := range p.monitors {
+		if m.t.Transaction != "" {
+			transactions[m.t.Transaction] = append(transactions[m.t.Transaction], m.t)
+		} else if m.t.ReloadGroup != "" {
+			groups[m.t.ReloadGroup] = append(groups[m.t.ReloadGroup], m.t)
+		}
+	}
+	p.unitsMu.Lock()
+	p.transactions, p.groups = transactions, groups
+	p.unitsMu.Unlock()
+	for _, m := range started {
+		log.Debug("Monitoring template resource " + m.t.name)
+		go p.monitorPrefix(m)
+	}
+}
+
+// unit returns the template resources processed together with t: the
+// members of its transaction or of its reload group, so that the reload
+// command of a group runs once when several members changed.
+func (p *watchProcessor) unit(t *TemplateResource) []*TemplateResource {
+	if t.Transaction == "" && t.ReloadGroup == "" {
+		return []*TemplateResource{t}
+	}
+	p.unitsMu.Lock()
+	defer p.unitsMu.Unlock()
+	members := p.transactions[t.Transaction]
+	if t.Transaction == "" {
+		members = p.groups[t.ReloadGroup]
+	}
+	u := make([]*TemplateResource, len(members))
+	copy(u, members)
+	return u
+}
+
+func (p *watchProcessor) Status() []Status {
+	return p.status.list()
+}
+
+func (p *watchProcessor) Trigger(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if name == "" {
+		for _, m := range p.monitors {
+			signal(m.changed)
+		}
+		return nil
+	}
+	m, ok := p.monitors[name]
+	if !ok {
+		return ErrUnknownTemplate
+	}
+	signal(m.changed)
+	return nil
+}
+
+func (p *watchProcessor) Reload() {
+	signal(p.reload)
+}
+
+// signal sends on a channel with a buffer of one without blocking, so
+// that pending signals are coalesced.
+func signal(c chan<- struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
 	}
-	p.wg.Wait()
 }
 
-func (p *watchProcessor) monitorPrefix(t *TemplateResource) {
-	defer p.wg.Done()
-	keys := util.AppendPrefix(t.Prefix, t.Keys)
+func (p *watchProcessor) monitorPrefix(m *monitor) {
+	defer close(m.done)
+	for _, s := range m.t.stores {
+		go p.watchStore(m, s)
+	}
+	rendered := false
 	for {
-		index, err := t.storeClient.WatchPrefix(t.Prefix, keys, t.lastIndex, p.stopChan)
+		select {
+		case <-m.stopChan:
+			return
+		case <-m.changed:
+			if rendered && !m.quiesce() {
+				return
+			}
+			rendered = true
+			if err := p.process(p.unit(m.t)); err != nil {
+				p.errChan <- err
+			}
+		}
+	}
+}
+
+// process processes the unit u returned by unit.
+func (p *watchProcessor) process(u []*TemplateResource) error {
+	t := u[0]
+	if t.Transaction == "" && t.ReloadGroup == "" {
+		p.reloads.begin(u)
+		return p.status.process(u)
+	}
+	p.txMu.Lock()
+	defer p.txMu.Unlock()
+	p.reloads.begin(u)
+	if t.Transaction != "" {
+		return p.status.process(u)
+	}
+	var lastErr error
+	for _, t := range u {
+		if err := p.status.process([]*TemplateResource{t}); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// quiesce collapses a burst of changes into one. It waits until no change
+// was signalled for the min_wait of the template resource, or for at most
+// max_wait. It returns false if m was stopped meanwhile.
+func (m *monitor) quiesce() bool {
+	if m.t.MinWait <= 0 {
+		return true
+	}
+	deadline := time.After(m.t.MaxWait)
+	for {
+		select {
+		case <-m.stopChan:
+			return false
+		case <-m.changed:
+		case <-time.After(m.t.MinWait):
+			return true
+		case <-deadline:
+			return true
+		}
+	}
+}
+
+// watchStore watches the keys the template resource of m reads from s and
+// signals m whenever they change. Changes that arrive while the template
+// resource is being processed are coalesced into a single signal.
+func (p *watchProcessor) watchStore(m *monitor, s *storeBinding) {
+	t := m.t
+	keys := util.AppendPrefix(t.Prefix, s.keys)
+	for {
+		index, err := s.client.WatchPrefix(t.Prefix, keys, s.lastIndex, m.stopChan)
+		select {
+		case <-m.stopChan:
+			return
+		default:
+		}
 		if err != nil {
-			p.errChan <- err
+			backendWatchErrors.WithLabelValues(s.label()).Inc()
+			p.errChan <- &Error{Err: err, Fields: log.Fields{"template": t.name, "backend": s.label(), "key_prefix": t.Prefix}}
 			// Prevent backend errors from consuming all resources.
 			time.Sleep(time.Second * 2)
 			continue
 		}
-		t.lastIndex = index
-		if err := t.process(); err != nil {
-			p.errChan <- err
-		}
+		backendWatchWakeups.WithLabelValues(s.label()).Inc()
+		s.lastIndex = index
+		p.status.setIndex(t, s, index)
+		signal(m.changed)
 	}
 }
 
@@ -134,5 +505,25 @@ func getTemplateResources(config Config) ([]*TemplateResource, error) {
 		}
 		templates = append(templates, t)
 	}
+	if lastError == nil {
+		setSensitivePrefixes(templates)
+		setLoadedTemplates(templates)
+	}
 	return templates, lastError
 }
+
+// setSensitivePrefixes marks the keys of the sensitive template resources
+// in ts as sensitive, for the logs of the backends. The keys of template
+// resources loaded before are not sensitive anymore.
+func setSensitivePrefixes(ts []*TemplateResource) {
+	var prefixes []string
+	for _, t := range ts {
+		if !t.Sensitive {
+			continue
+		}
+		for _, s := range t.stores {
+			prefixes = append(prefixes, util.AppendPrefix(t.Prefix, s.keys)...)
+		}
+	}
+	log.SetSensitivePrefixes(prefixes)
+}
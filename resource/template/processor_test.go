@@ -0,0 +1,374 @@
+package template
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/confd/log"
+)
+
+// staticStore is a backend serving fixed values that never change.
+type staticStore map[string]string
+
+func (s staticStore) GetValues(keys []string) (map[string]string, error) {
+	return s, nil
+}
+
+func (s staticStore) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	if waitIndex == 0 {
+		return 1, nil
+	}
+	<-stopChan
+	return waitIndex, nil
+}
+
+// changingStore is a backend whose values change when set is called.
+type changingStore struct {
+	mu      sync.Mutex
+	values  map[string]string
+	index   uint64
+	changed chan struct{}
+}
+
+func newChangingStore(values map[string]string) *changingStore {
+	return &changingStore{values: values, index: 1, changed: make(chan struct{})}
+}
+
+// set changes the values of the keys in values at once.
+func (s *changingStore) set(values map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range values {
+		s.values[k] = v
+	}
+	s.index++
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *changingStore) GetValues(keys []string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := make(map[string]string, len(s.values))
+	for k, v := range s.values {
+		values[k] = v
+	}
+	return values, nil
+}
+
+func (s *changingStore) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	for {
+		s.mu.Lock()
+		index, changed := s.index, s.changed
+		s.mu.Unlock()
+		if index > waitIndex {
+			return index, nil
+		}
+		select {
+		case <-changed:
+		case <-stopChan:
+			return waitIndex, nil
+		}
+	}
+}
+
+// waitFor polls cond until it holds or a few seconds passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestIntervalProcessorInvalidReload(t *testing.T) {
+	log.SetLevel("panic")
+	defer log.SetLevel("warn")
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+	dest := filepath.Join(tempConfDir, "foo.conf")
+	if err := ioutil.WriteFile(filepath.Join(tempConfDir, "templates", "foo.tmpl"), []byte(`foo = {{getv "/foo"}}`), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(tempConfDir, "conf.d", "foo.toml"), []byte(`
+[template]
+src = "foo.tmpl"
+dest = "`+dest+`"
+keys = ["/foo"]
+`), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	store := newChangingStore(map[string]string{"/foo": "bar"})
+	c := Config{
+		ConfDir:     tempConfDir,
+		ConfigDir:   filepath.Join(tempConfDir, "conf.d"),
+		StoreClient: store,
+		TemplateDir: filepath.Join(tempConfDir, "templates"),
+	}
+	stopChan := make(chan bool)
+	doneChan := make(chan bool)
+	p := IntervalProcessor(c, stopChan, doneChan, make(chan error, 10), 3600)
+	go p.Process()
+	defer func() {
+		close(stopChan)
+		<-doneChan
+	}()
+
+	contents := func(want string) func() bool {
+		return func() bool {
+			got, _ := ioutil.ReadFile(dest)
+			return string(got) == want
+		}
+	}
+	waitFor(t, "the initial render", contents("foo = bar"))
+
+	// A half written template resource does not stop confd, which keeps
+	// processing the running template resources.
+	if err := ioutil.WriteFile(filepath.Join(tempConfDir, "conf.d", "bar.toml"), []byte("[template"), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	time.Sleep(500 * time.Millisecond)
+	store.set(map[string]string{"/foo": "baz"})
+	if err := p.Trigger(""); err != nil {
+		t.Fatal(err.Error())
+	}
+	waitFor(t, "the changed value", contents("foo = baz"))
+}
+
+func TestWatchProcessorHotReload(t *testing.T) {
+	log.SetLevel("warn")
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+	dest := filepath.Join(tempConfDir, "foo.conf")
+	srcTemplateFile := filepath.Join(tempConfDir, "templates", "foo.tmpl")
+	templateResourcePath := filepath.Join(tempConfDir, "conf.d", "foo.toml")
+
+	c := Config{
+		ConfDir:     tempConfDir,
+		ConfigDir:   filepath.Join(tempConfDir, "conf.d"),
+		StoreClient: staticStore{"/foo": "bar"},
+		TemplateDir: filepath.Join(tempConfDir, "templates"),
+	}
+	stopChan := make(chan bool)
+	doneChan := make(chan bool)
+	errChan := make(chan error, 10)
+	p := WatchProcessor(c, stopChan, doneChan, errChan)
+	go p.Process()
+
+	contents := func(want string) func() bool {
+		return func() bool {
+			got, _ := ioutil.ReadFile(dest)
+			return string(got) == want
+		}
+	}
+
+	// A new template resource is picked up.
+	if err := ioutil.WriteFile(srcTemplateFile, []byte(`foo = {{getv "/foo"}}`), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	err = ioutil.WriteFile(templateResourcePath, []byte(`
+[template]
+src = "foo.tmpl"
+dest = "`+dest+`"
+keys = ["/foo"]
+`), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	waitFor(t, "the new template resource", contents("foo = bar"))
+
+	// A changed source template is rendered again.
+	if err := ioutil.WriteFile(srcTemplateFile, []byte(`foo: {{getv "/foo"}}`), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	waitFor(t, "the changed source template", contents("foo: bar"))
+
+	// A removed template resource is stopped.
+	if err := os.Remove(templateResourcePath); err != nil {
+		t.Fatal(err.Error())
+	}
+	waitFor(t, "the removed template resource", func() bool { return len(p.Status()) == 0 })
+
+	close(stopChan)
+	select {
+	case <-doneChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Process() did not return after stopChan was closed")
+	}
+	select {
+	case err := <-errChan:
+		t.Errorf("unexpected error: %s", err.Error())
+	default:
+	}
+}
+
+func TestMonitorQuiesce(t *testing.T) {
+	m := newMonitor(&TemplateResource{MinWait: 100 * time.Millisecond, MaxWait: 300 * time.Millisecond})
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(20 * time.Millisecond):
+				signal(m.changed)
+			}
+		}
+	}()
+
+	// Changes keep arriving, so max_wait bounds the wait.
+	start := time.Now()
+	if !m.quiesce() {
+		t.Fatal("quiesce() = false, want true")
+	}
+	if d := time.Since(start); d < 300*time.Millisecond || d > 2*time.Second {
+		t.Errorf("quiesce() took %s, want about max_wait", d)
+	}
+
+	close(m.stopChan)
+	if m.quiesce() {
+		t.Error("quiesce() = true after the monitor was stopped")
+	}
+}
+
+func TestWatchProcessorReloadGroup(t *testing.T) {
+	log.SetLevel("warn")
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+	reloads := filepath.Join(tempConfDir, "reloads")
+	for _, name := range []string{"a", "b"} {
+		err := ioutil.WriteFile(filepath.Join(tempConfDir, "templates", name+".tmpl"), []byte(`{{getv "/`+name+`"}}`), 0644)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		err = ioutil.WriteFile(filepath.Join(tempConfDir, "conf.d", name+".toml"), []byte(`
+[template]
+src = "`+name+`.tmpl"
+dest = "`+filepath.Join(tempConfDir, name+".conf")+`"
+keys = ["/`+name+`"]
+reload_cmd = "echo reload >> `+reloads+`"
+reload_group = "foo"
+`), 0644)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	store := newChangingStore(map[string]string{"/a": "1", "/b": "1"})
+	c := Config{
+		ConfDir:     tempConfDir,
+		ConfigDir:   filepath.Join(tempConfDir, "conf.d"),
+		StoreClient: store,
+		TemplateDir: filepath.Join(tempConfDir, "templates"),
+	}
+	stopChan := make(chan bool)
+	doneChan := make(chan bool)
+	errChan := make(chan error, 10)
+	p := WatchProcessor(c, stopChan, doneChan, errChan)
+	go p.Process()
+	defer func() {
+		close(stopChan)
+		<-doneChan
+	}()
+
+	contents := func(path, want string) func() bool {
+		return func() bool {
+			got, _ := ioutil.ReadFile(path)
+			return string(got) == want
+		}
+	}
+	rendered := func(value string) func() bool {
+		return func() bool {
+			return contents(filepath.Join(tempConfDir, "a.conf"), value)() &&
+				contents(filepath.Join(tempConfDir, "b.conf"), value)()
+		}
+	}
+	waitFor(t, "the initial render", rendered("1"))
+	waitFor(t, "the initial reload", contents(reloads, "reload\n"))
+
+	store.set(map[string]string{"/a": "2", "/b": "2"})
+	waitFor(t, "the changed values", rendered("2"))
+	// Give a second reload the time to happen.
+	time.Sleep(500 * time.Millisecond)
+	if got, _ := ioutil.ReadFile(reloads); string(got) != "reload\nreload\n" {
+		t.Errorf("got reloads %q, want one per change", got)
+	}
+	select {
+	case err := <-errChan:
+		t.Errorf("unexpected error: %s", err.Error())
+	default:
+	}
+}
+
+func TestWatchProcessorStopDuringUnit(t *testing.T) {
+	log.SetLevel("warn")
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+	for _, name := range []string{"a", "b"} {
+		err := ioutil.WriteFile(filepath.Join(tempConfDir, "templates", name+".tmpl"), []byte(`{{getv "/`+name+`"}}`), 0644)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		err = ioutil.WriteFile(filepath.Join(tempConfDir, "conf.d", name+".toml"), []byte(`
+[template]
+src = "`+name+`.tmpl"
+dest = "`+filepath.Join(tempConfDir, name+".conf")+`"
+keys = ["/`+name+`"]
+reload_group = "foo"
+`), 0644)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	c := Config{
+		ConfDir:     tempConfDir,
+		ConfigDir:   filepath.Join(tempConfDir, "conf.d"),
+		StoreClient: staticStore{"/a": "1", "/b": "1"},
+		TemplateDir: filepath.Join(tempConfDir, "templates"),
+	}
+	p := WatchProcessor(c, make(chan bool), make(chan bool), make(chan error, 10)).(*watchProcessor)
+	ts, err := getTemplateResources(c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	p.update(ts)
+
+	// A member changes while update holds mu to stop it, as when conf.d
+	// is reloaded at the same time.
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m := p.monitors["a.toml"]
+	signal(m.changed)
+	time.Sleep(100 * time.Millisecond)
+	stopped := make(chan struct{})
+	go func() {
+		m.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopping a member of a reload group deadlocked")
+	}
+	p.monitors["b.toml"].stop()
+}
@@ -0,0 +1,114 @@
+package template
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/kelseyhightower/confd/log"
+	util "github.com/kelseyhightower/confd/util"
+)
+
+// reloadDelay is how long a dirWatcher waits for more events before
+// reporting a change, so that saving a file reloads only once.
+const reloadDelay = 250 * time.Millisecond
+
+// A dirWatcher reports changes to the files in a set of directory trees,
+// such as the template resources in conf.d and their templates.
+type dirWatcher struct {
+	watcher *fsnotify.Watcher
+	changes chan struct{}
+}
+
+// newDirWatcher watches dirs and the directories below them. Directories
+// that do not exist are skipped.
+func newDirWatcher(dirs ...string) (*dirWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &dirWatcher{watcher: watcher, changes: make(chan struct{}, 1)}
+	for _, dir := range dirs {
+		if !util.IsFileExist(dir) {
+			log.Debug("Not watching missing directory " + dir)
+			continue
+		}
+		if err := w.add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+	go w.run()
+	return w, nil
+}
+
+// add watches dir and the directories below it.
+func (w *dirWatcher) add(dir string) error {
+	dirs, err := util.RecursiveDirsLookup(dir, "*")
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		log.Debug("Watching " + d + " for template resource changes")
+		if err := w.watcher.Add(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *dirWatcher) run() {
+	var delay <-chan time.Time
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&fsnotify.Create != 0 {
+				if isDir, err := util.IsDirectory(event.Name); err == nil && isDir {
+					if err := w.add(event.Name); err != nil {
+						log.Warning(fmt.Sprintf("Cannot watch %s: %s", event.Name, err.Error()))
+					}
+				}
+			}
+			delay = time.After(reloadDelay)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warning("Watching template resources failed: " + err.Error())
+		case <-delay:
+			delay = nil
+			signal(w.changes)
+		}
+	}
+}
+
+// Changes returns a channel that receives a value after files changed. It
+// is nil, and never receives, on a nil dirWatcher.
+func (w *dirWatcher) Changes() <-chan struct{} {
+	if w == nil {
+		return nil
+	}
+	return w.changes
+}
+
+// Close stops watching.
+func (w *dirWatcher) Close() {
+	if w != nil {
+		w.watcher.Close()
+	}
+}
+
+// watchConfig watches the conf.d and templates directories of config. On
+// failure it logs a warning and returns nil, so that confd keeps running
+// without hot reload.
+func watchConfig(config Config) *dirWatcher {
+	w, err := newDirWatcher(config.ConfigDir, config.TemplateDir)
+	if err != nil {
+		log.Warning("Cannot watch template resources for changes, restart or send SIGHUP to reload them: " + err.Error())
+		return nil
+	}
+	return w
+}
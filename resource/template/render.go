@@ -0,0 +1,71 @@
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Render renders the named template resources, or all of them if names is
+// empty, without syncing them: destination files are not modified and
+// check and reload commands do not run. The output of each template
+// resource is written to dir, at its destination path below dir, or to w
+// if dir is empty.
+// It returns an error if any.
+func Render(config Config, names []string, dir string, w io.Writer) error {
+	ts, err := getTemplateResources(config)
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		byName := make(map[string]*TemplateResource, len(ts))
+		for _, t := range ts {
+			byName[t.name] = t
+		}
+		ts = ts[:0]
+		for _, name := range names {
+			t, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("%s: %s", ErrUnknownTemplate, name)
+			}
+			ts = append(ts, t)
+		}
+	}
+
+	for i, t := range ts {
+		if err := t.setFileMode(); err != nil {
+			return err
+		}
+		if err := t.setVars(); err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := t.render(&buf); err != nil {
+			return fmt.Errorf("%s: %s", t.name, err)
+		}
+
+		if dir != "" {
+			path := filepath.Join(dir, t.Dest)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(path, buf.Bytes(), t.FileMode); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(ts) > 1 {
+			if i > 0 {
+				io.WriteString(w, "\n")
+			}
+			fmt.Fprintf(w, "==> %s <==\n", t.Dest)
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package template
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kelseyhightower/confd/log"
+)
+
+func TestRender(t *testing.T) {
+	log.SetLevel("warn")
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+	dest := filepath.Join(tempConfDir, "dest", "foo.conf")
+	files := map[string]string{
+		"templates/foo.tmpl": `foo = {{getv "/foo"}}` + "\n",
+		"templates/bar.tmpl": `bar = {{getv "/bar"}}` + "\n",
+		"conf.d/foo.toml": `
+[template]
+src = "foo.tmpl"
+dest = "` + dest + `"
+keys = ["/foo"]
+mode = "0600"
+check_cmd = "false"
+reload_cmd = "false"
+`,
+		"conf.d/bar.toml": `
+[template]
+src = "bar.tmpl"
+dest = "/etc/bar.conf"
+keys = ["/bar"]
+`,
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(tempConfDir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	c := Config{
+		ConfDir:     tempConfDir,
+		ConfigDir:   filepath.Join(tempConfDir, "conf.d"),
+		StoreClient: staticStore{"/foo": "a", "/bar": "b"},
+		TemplateDir: filepath.Join(tempConfDir, "templates"),
+	}
+
+	var buf bytes.Buffer
+	if err := Render(c, []string{"foo.toml"}, "", &buf); err != nil {
+		t.Fatal(err.Error())
+	}
+	if got, want := buf.String(), "foo = a\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("dest was written")
+	}
+
+	buf.Reset()
+	if err := Render(c, nil, "", &buf); err != nil {
+		t.Fatal(err.Error())
+	}
+	want := "==> /etc/bar.conf <==\nbar = b\n\n==> " + dest + " <==\nfoo = a\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	out := filepath.Join(tempConfDir, "out")
+	if err := Render(c, nil, out, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+	fi, err := os.Stat(filepath.Join(out, dest))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if fi.Mode() != 0600 {
+		t.Errorf("mode = %s, want %s", fi.Mode(), os.FileMode(0600))
+	}
+	got, _ := ioutil.ReadFile(filepath.Join(out, "etc", "bar.conf"))
+	if string(got) != "bar = b\n" {
+		t.Errorf("got %q, want %q", got, "bar = b\n")
+	}
+
+	if err := Render(c, []string{"baz.toml"}, "", &buf); err == nil {
+		t.Error("expected an error for an unknown template resource")
+	}
+}
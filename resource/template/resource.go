@@ -4,32 +4,42 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
-	"runtime"
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/kelseyhightower/confd/backends"
 	"github.com/kelseyhightower/confd/log"
 	util "github.com/kelseyhightower/confd/util"
 	"github.com/kelseyhightower/memkv"
+	"golang.org/x/crypto/openpgp"
 )
 
 type Config struct {
 	ConfDir       string `toml:"confdir"`
 	ConfigDir     string
+	Diff          bool `toml:"diff"`
 	KeepStageFile bool
-	Noop          bool   `toml:"noop"`
-	Prefix        string `toml:"prefix"`
+	MaxWait       time.Duration `toml:"max_wait"`
+	MinWait       time.Duration `toml:"min_wait"`
+	Noop          bool          `toml:"noop"`
+	Prefix        string        `toml:"prefix"`
+	Rollback      bool          `toml:"rollback"`
+	SecretKeyring string        `toml:"secret_keyring"`
 	StoreClient   backends.StoreClient
+	StoreClients  map[string]backends.StoreClient
 	SyncOnly      bool `toml:"sync-only"`
 	TemplateDir   string
+	// Updated, if set, is called with the destination file of a template
+	// resource after it was updated.
+	Updated func(dest string)
 }
 
 // TemplateResourceConfig holds the parsed template resource.
@@ -39,35 +49,76 @@ type TemplateResourceConfig struct {
 
 // TemplateResource is the representation of a parsed template resource.
 type TemplateResource struct {
-	CheckCmd      string `toml:"check_cmd"`
+	Backends      []string      `toml:"backends"`
+	CheckArgs     []string      `toml:"check_args"`
+	CheckCmd      string        `toml:"check_cmd"`
+	CheckTimeout  time.Duration `toml:"check_timeout"`
 	Dest          string
 	FileMode      os.FileMode
 	Gid           int
 	Keys          []string
+	MaxWait       time.Duration `toml:"max_wait"`
+	MinWait       time.Duration `toml:"min_wait"`
 	Mode          string
+	Pidfile       string `toml:"pidfile"`
 	Prefix        string
-	ReloadCmd     string `toml:"reload_cmd"`
+	ReloadArgs    []string      `toml:"reload_args"`
+	ReloadCmd     string        `toml:"reload_cmd"`
+	ReloadGroup   string        `toml:"reload_group"`
+	ReloadSignal  string        `toml:"reload_signal"`
+	ReloadTimeout time.Duration `toml:"reload_timeout"`
+	Rollback      bool          `toml:"rollback"`
+	Sensitive     bool          `toml:"sensitive"`
 	Src           string
 	StageFile     *os.File
+	Transaction   string `toml:"transaction"`
 	Uid           int
 	funcMap       map[string]interface{}
-	lastIndex     uint64
 	keepStageFile bool
+	name          string
+	hash          string
+	valuesHash    string
+	vars          map[string]string
+	secrets       []string
+	cache         *renderCache
+	reloads       *reloadGroups
 	noop          bool
+	showDiff      bool
 	store         memkv.Store
-	storeClient   backends.StoreClient
+	stores        []*storeBinding
 	syncOnly      bool
-	PGPPrivateKey []byte
+	updated       func(dest string)
+	PGPPrivateKey string `toml:"pgp_private_key"`
+	keyring       openpgp.EntityList
+}
+
+// A storeBinding is a backend a template resource reads keys from. Keys
+// from named backends are namespaced with the backend name in the template.
+type storeBinding struct {
+	name      string
+	client    backends.StoreClient
+	keys      []string
+	lastIndex uint64
+}
+
+// label returns the name the backend is reported under, default for the
+// unnamed backend.
+func (s *storeBinding) label() string {
+	if s.name == "" {
+		return "default"
+	}
+	return s.name
 }
 
 var ErrEmptySrc = errors.New("empty src template")
 
+// logger returns a log entry with the template resource attached.
+func (t *TemplateResource) logger() *log.Entry {
+	return log.With(log.Fields{"template": t.name, "dest": t.Dest})
+}
+
 // NewTemplateResource creates a TemplateResource.
 func NewTemplateResource(path string, config Config) (*TemplateResource, error) {
-	if config.StoreClient == nil {
-		return nil, errors.New("A valid StoreClient is required.")
-	}
-
 	// Set the default uid and gid so we can determine if it was
 	// unset from configuration.
 	tc := &TemplateResourceConfig{TemplateResource{Uid: -1, Gid: -1}}
@@ -79,14 +130,37 @@ func NewTemplateResource(path string, config Config) (*TemplateResource, error)
 	}
 
 	tr := tc.TemplateResource
+	tr.hash = fileHash(path)
+	tr.cache = &renderCache{}
+	tr.name = path
+	if rel, err := filepath.Rel(config.ConfigDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		tr.name = rel
+	}
+	if err := tr.bindStores(config); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource %s - %s", path, err.Error())
+	}
 	tr.keepStageFile = config.KeepStageFile
 	tr.noop = config.Noop
-	tr.storeClient = config.StoreClient
+	tr.showDiff = config.Diff || config.Noop
 	tr.funcMap = newFuncMap()
 	tr.store = memkv.New()
 	tr.syncOnly = config.SyncOnly
+	tr.updated = config.Updated
+	tr.Rollback = tr.Rollback || config.Rollback
 	addFuncs(tr.funcMap, tr.store.FuncMap)
 
+	if tr.PGPPrivateKey == "" {
+		tr.PGPPrivateKey = config.SecretKeyring
+	}
+	if tr.PGPPrivateKey != "" {
+		tr.keyring, err = readKeyring(tr.PGPPrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot load PGP private key %s - %s", tr.PGPPrivateKey, err.Error())
+		}
+	}
+	addCryptFuncs(tr.funcMap, &tr.store, tr.decrypt)
+	tr.addRedactFuncs(tr.funcMap)
+
 	if config.Prefix != "" {
 		tr.Prefix = config.Prefix
 	}
@@ -99,6 +173,39 @@ func NewTemplateResource(path string, config Config) (*TemplateResource, error)
 		return nil, ErrEmptySrc
 	}
 
+	if tr.CheckCmd != "" && len(tr.CheckArgs) > 0 {
+		return nil, fmt.Errorf("Cannot process template resource %s - check_cmd and check_args cannot both be set", path)
+	}
+	if tr.ReloadCmd != "" && len(tr.ReloadArgs) > 0 {
+		return nil, fmt.Errorf("Cannot process template resource %s - reload_cmd and reload_args cannot both be set", path)
+	}
+	if tr.ReloadSignal != "" {
+		if tr.ReloadCmd != "" || len(tr.ReloadArgs) > 0 {
+			return nil, fmt.Errorf("Cannot process template resource %s - reload_signal cannot be used with reload_cmd or reload_args", path)
+		}
+		if tr.Pidfile == "" {
+			return nil, fmt.Errorf("Cannot process template resource %s - reload_signal requires pidfile", path)
+		}
+		if _, err := util.ParseSignal(tr.ReloadSignal); err != nil {
+			return nil, fmt.Errorf("Cannot process template resource %s - invalid reload_signal: %s", path, err.Error())
+		}
+	} else if tr.Pidfile != "" {
+		return nil, fmt.Errorf("Cannot process template resource %s - pidfile requires reload_signal", path)
+	}
+
+	if tr.MinWait == 0 {
+		tr.MinWait = config.MinWait
+	}
+	if tr.MaxWait == 0 {
+		tr.MaxWait = config.MaxWait
+	}
+	if tr.MaxWait == 0 {
+		tr.MaxWait = 4 * tr.MinWait
+	}
+	if tr.MaxWait < tr.MinWait {
+		return nil, fmt.Errorf("Cannot process template resource %s - max_wait %s is less than min_wait %s", path, tr.MaxWait, tr.MinWait)
+	}
+
 	if tr.Uid == -1 {
 		tr.Uid = os.Geteuid()
 	}
@@ -111,23 +218,82 @@ func NewTemplateResource(path string, config Config) (*TemplateResource, error)
 	return &tr, nil
 }
 
+// bindStores assigns the keys of the template resource to the store
+// clients they are read from. Without backends all keys are read from the
+// default store client. Otherwise each key must start with the name of one
+// of the backends, which is stripped before the key is looked up.
+func (t *TemplateResource) bindStores(config Config) error {
+	if len(t.Backends) == 0 {
+		if config.StoreClient == nil {
+			return errors.New("A valid StoreClient is required.")
+		}
+		t.stores = []*storeBinding{{client: config.StoreClient, keys: t.Keys}}
+		return nil
+	}
+
+	bindings := make(map[string]*storeBinding)
+	for _, name := range t.Backends {
+		client, ok := config.StoreClients[name]
+		if !ok {
+			return fmt.Errorf("unknown backend %q", name)
+		}
+		b := &storeBinding{name: name, client: client}
+		bindings[name] = b
+		t.stores = append(t.stores, b)
+	}
+	for _, k := range t.Keys {
+		k = path.Join("/", k)
+		name := strings.SplitN(k[1:], "/", 2)[0]
+		b, ok := bindings[name]
+		if !ok {
+			return fmt.Errorf("key %s does not start with one of the backends %s", k, strings.Join(t.Backends, ", "))
+		}
+		b.keys = append(b.keys, path.Join("/", strings.TrimPrefix(k, "/"+name)))
+	}
+	return nil
+}
+
 // setVars sets the Vars for template resource.
 func (t *TemplateResource) setVars() error {
-	var err error
-	log.Debug("Retrieving keys from store")
-	log.Debug("Key prefix set to " + t.Prefix)
-
-	result, err := t.storeClient.GetValues(util.AppendPrefix(t.Prefix, t.Keys))
-	if err != nil {
-		return err
+	t.logger().Debug("Retrieving keys from store")
+	t.logger().With(log.Fields{"key_prefix": t.Prefix}).Debug("Key prefix set to " + t.Prefix)
+
+	results := make([]map[string]string, len(t.stores))
+	for i, s := range t.stores {
+		logger := t.logger().With(log.Fields{"backend": s.label(), "key_prefix": t.Prefix})
+		if s.name != "" {
+			logger.Debug("Retrieving keys from backend " + s.name)
+		}
+		start := time.Now()
+		result, err := s.client.GetValues(util.AppendPrefix(t.Prefix, s.keys))
+		duration := time.Since(start)
+		backendGetValuesDuration.WithLabelValues(s.label()).Observe(duration.Seconds())
+		if err != nil {
+			backendGetValuesErrors.WithLabelValues(s.label()).Inc()
+			return err
+		}
+		logger.With(log.Fields{"duration": duration.String()}).Debug("Got the following map from store: %v", log.RedactMap(result))
+		results[i] = result
 	}
-	log.Debug("Got the following map from store: %v", result)
 
 	t.store.Purge()
 
-	for k, v := range result {
-		t.store.Set(path.Join("/", strings.TrimPrefix(k, t.Prefix)), v)
+	vars := make(map[string]string)
+	t.secrets = nil
+	for i, s := range t.stores {
+		for k, v := range results[i] {
+			key := path.Join("/", s.name, strings.TrimPrefix(k, t.Prefix))
+			vars[key] = v
+			if t.Sensitive || log.MatchesSensitiveKeys(k) || log.MatchesSensitiveKeys(key) {
+				t.secrets = append(t.secrets, v)
+			}
+		}
 	}
+	for k, v := range vars {
+		t.store.Set(k, v)
+	}
+	t.vars = vars
+	t.valuesHash = hashValues(vars)
 	return nil
 }
 
@@ -136,26 +302,13 @@ func (t *TemplateResource) setVars() error {
 // StageFile for the template resource.
 // It returns an error if any.
 func (t *TemplateResource) createStageFile() error {
-	log.Debug("Using source template " + t.Src)
-
-	if !util.IsFileExist(t.Src) {
-		return errors.New("Missing template: " + t.Src)
-	}
-
-	log.Debug("Compiling source template " + t.Src)
-
-	tmpl, err := template.New(filepath.Base(t.Src)).Funcs(t.funcMap).ParseFiles(t.Src)
-	if err != nil {
-		return fmt.Errorf("Unable to process template %s, %s", t.Src, err)
-	}
-
 	// create TempFile in Dest directory to avoid cross-filesystem issues
 	temp, err := ioutil.TempFile(filepath.Dir(t.Dest), "."+filepath.Base(t.Dest))
 	if err != nil {
 		return err
 	}
 
-	if err = tmpl.Execute(temp, nil); err != nil {
+	if err := t.render(temp); err != nil {
 		temp.Close()
 		os.Remove(temp.Name())
 		return err
@@ -170,6 +323,26 @@ func (t *TemplateResource) createStageFile() error {
 	return nil
 }
 
+// render processes the src template, writing the result to w.
+// It returns an error if any.
+func (t *TemplateResource) render(w io.Writer) error {
+	t.logger().Debug("Using source template " + t.Src)
+
+	if !util.IsFileExist(t.Src) {
+		return errors.New("Missing template: " + t.Src)
+	}
+
+	tmpl, err := t.parseSrc()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = tmpl.Execute(w, nil)
+	templateRenderDuration.WithLabelValues(t.name).Observe(time.Since(start).Seconds())
+	return err
+}
+
 // sync compares the staged and dest config files and attempts to sync them
 // if they differ. sync will run a config check command if set before
 // overwriting the target config file. Finally, sync will run a reload command
@@ -178,57 +351,105 @@ func (t *TemplateResource) createStageFile() error {
 func (t *TemplateResource) sync() error {
 	staged := t.StageFile.Name()
 	if t.keepStageFile {
-		log.Info("Keeping staged file: " + staged)
+		t.logger().Info("Keeping staged file: " + staged)
 	} else {
 		defer os.Remove(staged)
 	}
 
-	log.Debug("Comparing candidate config to " + t.Dest)
-	ok, err := util.IsConfigChanged(staged, t.Dest)
-	if err != nil {
-		log.Error(err.Error())
+	ok := t.changed()
+	if ok && t.showDiff {
+		if err := t.printDiff(); err != nil {
+			t.logger().WithError(err).Error("Cannot show changes to " + t.Dest + ": " + err.Error())
+		}
 	}
 	if t.noop {
-		log.Warning("Noop mode enabled. " + t.Dest + " will not be modified")
+		t.logger().Warning("Noop mode enabled. " + t.Dest + " will not be modified")
 		return nil
 	}
 	if ok {
-		log.Info("Target config " + t.Dest + " out of sync")
-		if !t.syncOnly && t.CheckCmd != "" {
+		t.logger().Info("Target config " + t.Dest + " out of sync")
+		if !t.syncOnly && t.hasCheck() {
 			if err := t.check(); err != nil {
+				commandFailures.WithLabelValues(t.name, "check").Inc()
 				return errors.New("Config check failed: " + err.Error())
 			}
 		}
-		log.Debug("Overwriting target config " + t.Dest)
-		err := os.Rename(staged, t.Dest)
-		if err != nil {
-			if strings.Contains(err.Error(), "device or resource busy") {
-				log.Debug("Rename failed - target is likely a mount. Trying to write instead")
-				// try to open the file and write to it
-				var contents []byte
-				var rerr error
-				contents, rerr = ioutil.ReadFile(staged)
-				if rerr != nil {
-					return rerr
-				}
-				err := ioutil.WriteFile(t.Dest, contents, t.FileMode)
-				// make sure owner and group match the temp file, in case the file was created with WriteFile
-				os.Chown(t.Dest, t.Uid, t.Gid)
-				if err != nil {
-					return err
-				}
-			} else {
-				return err
+		var b *backup
+		if t.Rollback && !t.syncOnly && t.hasReload() {
+			var err error
+			if b, err = backupFile(t.Dest); err != nil {
+				return fmt.Errorf("Cannot back up %s: %s", t.Dest, err)
 			}
 		}
-		if !t.syncOnly && t.ReloadCmd != "" {
+		if err := t.install(); err != nil {
+			return err
+		}
+		if !t.syncOnly && t.hasReload() && t.ReloadGroup != "" && t.reloads != nil {
+			t.logger().Debug("Deferring reload of " + t.Dest + " until group " + t.ReloadGroup + " is synced")
+			t.reloads.deferReload(t, b)
+		} else if !t.syncOnly && t.hasReload() {
 			if err := t.reload(); err != nil {
+				commandFailures.WithLabelValues(t.name, "reload").Inc()
+				if b != nil {
+					err = rollback([]command{t.reloadCommand()}, []*backup{b}, err)
+					t.rolledBack(err)
+				}
 				return err
 			}
 		}
-		log.Info("Target config " + t.Dest + " has been updated")
+		t.logger().Info("Target config " + t.Dest + " has been updated")
+		if t.updated != nil {
+			t.updated(t.Dest)
+		}
 	} else {
-		log.Debug("Target config " + t.Dest + " in sync")
+		t.logger().Debug("Target config " + t.Dest + " in sync")
+	}
+	return nil
+}
+
+// changed compares the staged file to the destination file.
+// It returns true if they differ.
+func (t *TemplateResource) changed() bool {
+	t.logger().Debug("Comparing candidate config to " + t.Dest)
+	ok, err := util.IsConfigChanged(t.StageFile.Name(), t.Dest)
+	if err != nil {
+		t.logger().WithError(err).Error(err.Error())
+		templateFiles.WithLabelValues(t.name, "error").Inc()
+		return ok
+	}
+	if ok {
+		templateFiles.WithLabelValues(t.name, "changed").Inc()
+	} else {
+		templateFiles.WithLabelValues(t.name, "unchanged").Inc()
+	}
+	return ok
+}
+
+// install moves the staged file over the destination file.
+// It returns an error if any.
+func (t *TemplateResource) install() error {
+	staged := t.StageFile.Name()
+	t.logger().Debug("Overwriting target config " + t.Dest)
+	err := os.Rename(staged, t.Dest)
+	if err != nil {
+		if strings.Contains(err.Error(), "device or resource busy") {
+			t.logger().Debug("Rename failed - target is likely a mount. Trying to write instead")
+			// try to open the file and write to it
+			var contents []byte
+			var rerr error
+			contents, rerr = ioutil.ReadFile(staged)
+			if rerr != nil {
+				return rerr
+			}
+			err := ioutil.WriteFile(t.Dest, contents, t.FileMode)
+			// make sure owner and group match the temp file, in case the file was created with WriteFile
+			os.Chown(t.Dest, t.Uid, t.Gid)
+			if err != nil {
+				return err
+			}
+		} else {
+			return err
+		}
 	}
 	return nil
 }
@@ -240,65 +461,88 @@ func (t *TemplateResource) sync() error {
 // file.
 // It returns nil if the check command returns 0 and there are no other errors.
 func (t *TemplateResource) check() error {
-	var cmdBuffer bytes.Buffer
-	data := make(map[string]string)
+	data := make(map[string]interface{})
 	data["src"] = t.StageFile.Name()
-	tmpl, err := template.New("checkcmd").Parse(t.CheckCmd)
+	return runCheck(t.checkCommand(), data)
+}
+
+// runCheck executes the check command cmd after substituting data.
+func runCheck(cmd command, data map[string]interface{}) error {
+	var cmdBuffer bytes.Buffer
+	if len(cmd.args) > 0 {
+		// Each argument is substituted on its own, and passed as is.
+		args := make([]string, len(cmd.args))
+		for i, arg := range cmd.args {
+			tmpl, err := template.New("checkargs").Parse(arg)
+			if err != nil {
+				return err
+			}
+			cmdBuffer.Reset()
+			if err := tmpl.Execute(&cmdBuffer, data); err != nil {
+				return err
+			}
+			args[i] = cmdBuffer.String()
+		}
+		cmd.args = args
+		return runCommand(cmd)
+	}
+	tmpl, err := template.New("checkcmd").Parse(cmd.cmd)
 	if err != nil {
 		return err
 	}
 	if err := tmpl.Execute(&cmdBuffer, data); err != nil {
 		return err
 	}
-	return runCommand(cmdBuffer.String())
+	cmd.cmd = cmdBuffer.String()
+	return runCommand(cmd)
 }
 
 // reload executes the reload command.
 // It returns nil if the reload command returns 0.
 func (t *TemplateResource) reload() error {
-	return runCommand(t.ReloadCmd)
-}
-
-// runCommand is a shared function used by check and reload
-// to run the given command and log its output.
-// It returns nil if the given cmd returns 0.
-// The command can be run on unix and windows.
-func runCommand(cmd string) error {
-	log.Debug("Running " + cmd)
-	var c *exec.Cmd
-	if runtime.GOOS == "windows" {
-		c = exec.Command("cmd", "/C", cmd)
-	} else {
-		c = exec.Command("/bin/sh", "-c", cmd)
-	}
-
-	output, err := c.CombinedOutput()
-	if err != nil {
-		log.Error(fmt.Sprintf("%q", string(output)))
-		return err
-	}
-	log.Debug(fmt.Sprintf("%q", string(output)))
-	return nil
+	return runCommand(t.reloadCommand())
 }
 
 // process is a convenience function that wraps calls to the three main tasks
 // required to keep local configuration files in sync. First we gather vars
 // from the store, then we stage a candidate configuration file, and finally sync
 // things up.
+// Template resources in a reload group must have been marked with begin,
+// their reload command runs once the whole group was processed.
 // It returns an error if any.
-func (t *TemplateResource) process() error {
+func (t *TemplateResource) process() (err error) {
+	if t.reloads != nil {
+		defer func() {
+			if rerr := t.reloads.done(t); err == nil {
+				err = rerr
+			}
+		}()
+	}
 	if err := t.setFileMode(); err != nil {
 		return err
 	}
 	if err := t.setVars(); err != nil {
 		return err
 	}
+	if t.upToDate() {
+		t.logger().Debug("Target config " + t.Dest + " in sync, nothing changed since the last run")
+		templateFiles.WithLabelValues(t.name, "skipped").Inc()
+		templateLastSync.WithLabelValues(t.name).SetToCurrentTime()
+		return nil
+	}
+	if err := t.failedBefore(); err != nil {
+		return err
+	}
 	if err := t.createStageFile(); err != nil {
 		return err
 	}
 	if err := t.sync(); err != nil {
 		return err
 	}
+	if !t.noop {
+		t.synced()
+	}
+	templateLastSync.WithLabelValues(t.name).SetToCurrentTime()
 	return nil
 }
 
@@ -4,11 +4,14 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"text/template"
 
+	"github.com/kelseyhightower/confd/backends"
 	"github.com/kelseyhightower/confd/backends/env"
 	"github.com/kelseyhightower/confd/log"
+	util "github.com/kelseyhightower/confd/util"
 )
 
 // createTempDirs is a helper function which creates temporary directories
@@ -107,3 +110,329 @@ func TestProcessTemplateResources(t *testing.T) {
 		t.Errorf("Expected contents of dest == '%s', got %s", expected, string(results))
 	}
 }
+
+func TestProcessTemplateResourcesWithBackends(t *testing.T) {
+	log.SetLevel("warn")
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Errorf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+
+	srcTemplateFile := filepath.Join(tempConfDir, "templates", "foo.tmpl")
+	err = ioutil.WriteFile(srcTemplateFile, []byte(`foo = {{getv "/a/foo"}}, bar = {{getv "/b/bar"}}`), 0644)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	destFile, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Errorf("Failed to create destFile: %s", err.Error())
+	}
+	defer os.Remove(destFile.Name())
+
+	templateResourcePath := filepath.Join(tempConfDir, "conf.d", "foo.toml")
+	err = ioutil.WriteFile(templateResourcePath, []byte(`
+[template]
+src = "foo.tmpl"
+dest = "`+destFile.Name()+`"
+backends = ["a", "b"]
+keys = [
+  "/a/foo",
+  "/b/bar",
+]
+`), 0644)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	os.Setenv("FOO", "bar")
+	os.Setenv("BAR", "baz")
+	a, _ := env.NewEnvClient()
+	b, _ := env.NewEnvClient()
+	c := Config{
+		ConfDir:      tempConfDir,
+		ConfigDir:    filepath.Join(tempConfDir, "conf.d"),
+		StoreClients: map[string]backends.StoreClient{"a": a, "b": b},
+		TemplateDir:  filepath.Join(tempConfDir, "templates"),
+	}
+	if err := Process(c); err != nil {
+		t.Error(err.Error())
+	}
+	expected := "foo = bar, bar = baz"
+	results, err := ioutil.ReadFile(destFile.Name())
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if string(results) != expected {
+		t.Errorf("Expected contents of dest == '%s', got %s", expected, string(results))
+	}
+}
+
+func TestNewTemplateResourceBackendErrors(t *testing.T) {
+	log.SetLevel("warn")
+	a, _ := env.NewEnvClient()
+	c := Config{StoreClients: map[string]backends.StoreClient{"a": a}}
+	tests := []struct {
+		desc string
+		toml string
+	}{
+		{"unknown backend", "[template]\nsrc = \"foo.tmpl\"\nbackends = [\"c\"]\nkeys = [\"/c/foo\"]\n"},
+		{"key without backend", "[template]\nsrc = \"foo.tmpl\"\nbackends = [\"a\"]\nkeys = [\"/foo\"]\n"},
+	}
+	for _, tt := range tests {
+		f, err := ioutil.TempFile("", "")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(tt.toml)
+		f.Close()
+		if _, err := NewTemplateResource(f.Name(), c); err == nil {
+			t.Errorf("%s: expected an error", tt.desc)
+		}
+	}
+}
+
+func TestProcessTemplateResourcesReloadGroup(t *testing.T) {
+	log.SetLevel("warn")
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Errorf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+
+	srcTemplateFile := filepath.Join(tempConfDir, "templates", "foo.tmpl")
+	err = ioutil.WriteFile(srcTemplateFile, []byte(`foo = {{getv "/foo"}}`), 0644)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	checks := filepath.Join(tempConfDir, "checks")
+	reloads := filepath.Join(tempConfDir, "reloads")
+	for _, name := range []string{"a", "b", "c"} {
+		err = ioutil.WriteFile(filepath.Join(tempConfDir, "conf.d", name+".toml"), []byte(`
+[template]
+src = "foo.tmpl"
+dest = "`+filepath.Join(tempConfDir, name+".conf")+`"
+keys = ["/foo"]
+check_cmd = "echo `+name+` >> `+checks+`"
+reload_cmd = "echo reload >> `+reloads+`"
+reload_group = "foo"
+`), 0644)
+		if err != nil {
+			t.Error(err.Error())
+		}
+	}
+
+	c := Config{
+		ConfDir:     tempConfDir,
+		ConfigDir:   filepath.Join(tempConfDir, "conf.d"),
+		StoreClient: staticStore{"/foo": "bar"},
+		TemplateDir: filepath.Join(tempConfDir, "templates"),
+	}
+	if err := Process(c); err != nil {
+		t.Error(err.Error())
+	}
+	results, err := ioutil.ReadFile(checks)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if string(results) != "a\nb\nc\n" {
+		t.Errorf("Expected a check per template resource, got %q", string(results))
+	}
+	results, err = ioutil.ReadFile(reloads)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if string(results) != "reload\n" {
+		t.Errorf("Expected a single reload, got %q", string(results))
+	}
+}
+
+func TestProcessTemplateResourcesRollback(t *testing.T) {
+	log.SetLevel("warn")
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Errorf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+
+	srcTemplateFile := filepath.Join(tempConfDir, "templates", "foo.tmpl")
+	err = ioutil.WriteFile(srcTemplateFile, []byte(`foo = {{getv "/foo"}}`), 0644)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	dest := filepath.Join(tempConfDir, "foo.conf")
+	if err := ioutil.WriteFile(dest, []byte("foo = old"), 0600); err != nil {
+		t.Error(err.Error())
+	}
+	// The reload command only accepts the previous config.
+	err = ioutil.WriteFile(filepath.Join(tempConfDir, "conf.d", "foo.toml"), []byte(`
+[template]
+src = "foo.tmpl"
+dest = "`+dest+`"
+keys = ["/foo"]
+reload_cmd = "grep -q old `+dest+`"
+rollback = true
+`), 0644)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	c := Config{
+		ConfDir:     tempConfDir,
+		ConfigDir:   filepath.Join(tempConfDir, "conf.d"),
+		StoreClient: staticStore{"/foo": "new"},
+		TemplateDir: filepath.Join(tempConfDir, "templates"),
+	}
+	err = Process(c)
+	if err == nil || !strings.Contains(err.Error(), "restored previous config and reloaded it") {
+		t.Errorf("Expected the rollback to be reported, got %v", err)
+	}
+	results, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if string(results) != "foo = old" {
+		t.Errorf("Expected contents of dest == 'foo = old', got %s", string(results))
+	}
+	if fi, err := os.Stat(dest); err != nil || fi.Mode() != 0600 {
+		t.Errorf("Expected the mode of dest to be restored, got %v", fi.Mode())
+	}
+}
+
+func TestProcessTemplateResourcesTransaction(t *testing.T) {
+	log.SetLevel("warn")
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Errorf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+
+	srcTemplateFile := filepath.Join(tempConfDir, "templates", "foo.tmpl")
+	err = ioutil.WriteFile(srcTemplateFile, []byte(`foo = {{getv "/foo"}}`), 0644)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	destA := filepath.Join(tempConfDir, "a.conf")
+	destB := filepath.Join(tempConfDir, "b.conf")
+	reloads := filepath.Join(tempConfDir, "reloads")
+	// The check accepts the set only if both staged files are valid.
+	check := `grep -q ok {{index .staged "` + destA + `"}} && grep -q ok {{index .staged "` + destB + `"}}`
+	for name, dest := range map[string]string{"a": destA, "b": destB} {
+		err = ioutil.WriteFile(filepath.Join(tempConfDir, "conf.d", name+".toml"), []byte(`
+[template]
+src = "foo.tmpl"
+dest = "`+dest+`"
+keys = ["/foo"]
+check_cmd = '`+check+`'
+reload_cmd = "echo reload >> `+reloads+`"
+transaction = "foo"
+`), 0644)
+		if err != nil {
+			t.Error(err.Error())
+		}
+	}
+
+	c := Config{
+		ConfDir:     tempConfDir,
+		ConfigDir:   filepath.Join(tempConfDir, "conf.d"),
+		StoreClient: staticStore{"/foo": "bad"},
+		TemplateDir: filepath.Join(tempConfDir, "templates"),
+	}
+	if err := Process(c); err == nil {
+		t.Error("Expected the check of the transaction to fail")
+	}
+	for _, dest := range []string{destA, destB} {
+		if util.IsFileExist(dest) {
+			t.Errorf("Expected %s not to be written after a failed check", dest)
+		}
+	}
+
+	c.StoreClient = staticStore{"/foo": "ok"}
+	if err := Process(c); err != nil {
+		t.Error(err.Error())
+	}
+	for _, dest := range []string{destA, destB} {
+		results, err := ioutil.ReadFile(dest)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		if string(results) != "foo = ok" {
+			t.Errorf("Expected contents of %s == 'foo = ok', got %s", dest, string(results))
+		}
+	}
+	results, err := ioutil.ReadFile(reloads)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if string(results) != "reload\n" {
+		t.Errorf("Expected a single reload, got %q", string(results))
+	}
+}
+
+func TestProcessTemplateResourcesTransactionRollback(t *testing.T) {
+	log.SetLevel("panic")
+	defer log.SetLevel("warn")
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Errorf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+
+	srcTemplateFile := filepath.Join(tempConfDir, "templates", "foo.tmpl")
+	err = ioutil.WriteFile(srcTemplateFile, []byte(`foo = {{getv "/foo"}}`), 0644)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	reloads := filepath.Join(tempConfDir, "reloads")
+	destA := filepath.Join(tempConfDir, "a.conf")
+	destB := filepath.Join(tempConfDir, "b.conf")
+	// The reload command of b only accepts the previous config, after the
+	// one of a reloaded the new config.
+	for name, dest := range map[string]string{"a": destA, "b": destB} {
+		if err := ioutil.WriteFile(dest, []byte("foo = old"), 0644); err != nil {
+			t.Error(err.Error())
+		}
+		reload := "echo " + name + " >> " + reloads
+		if name == "b" {
+			reload += " && grep -q old " + dest
+		}
+		err = ioutil.WriteFile(filepath.Join(tempConfDir, "conf.d", name+".toml"), []byte(`
+[template]
+src = "foo.tmpl"
+dest = "`+dest+`"
+keys = ["/foo"]
+reload_cmd = "`+reload+`"
+transaction = "foo"
+rollback = true
+`), 0644)
+		if err != nil {
+			t.Error(err.Error())
+		}
+	}
+
+	c := Config{
+		ConfDir:     tempConfDir,
+		ConfigDir:   filepath.Join(tempConfDir, "conf.d"),
+		StoreClient: staticStore{"/foo": "new"},
+		TemplateDir: filepath.Join(tempConfDir, "templates"),
+	}
+	if err := Process(c); err == nil {
+		t.Error("Expected the reload of the transaction to fail")
+	}
+	for _, dest := range []string{destA, destB} {
+		if results, _ := ioutil.ReadFile(dest); string(results) != "foo = old" {
+			t.Errorf("Expected contents of %s == 'foo = old', got %s", dest, string(results))
+		}
+	}
+	// a reloaded the new config, so it reloads the restored one too.
+	results, err := ioutil.ReadFile(reloads)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if string(results) != "a\nb\na\nb\n" {
+		t.Errorf("Expected both reloads to run again, got %q", string(results))
+	}
+}
@@ -0,0 +1,98 @@
+package template
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kelseyhightower/confd/log"
+	util "github.com/kelseyhightower/confd/util"
+)
+
+// A backup holds the contents of a destination file before it was
+// overwritten, so that it can be restored if the reload command fails.
+type backup struct {
+	path   string
+	exists bool
+	data   []byte
+	mode   os.FileMode
+	uid    int
+	gid    int
+}
+
+// backupFile backs up the file at path, which may not exist.
+func backupFile(path string) (*backup, error) {
+	b := &backup{path: path}
+	if !util.IsFileExist(path) {
+		return b, nil
+	}
+	fi, err := util.FileStat(path)
+	if err != nil {
+		return nil, err
+	}
+	b.data, err = ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b.exists = true
+	b.mode = fi.Mode
+	b.uid = int(fi.Uid)
+	b.gid = int(fi.Gid)
+	return b, nil
+}
+
+// restore puts the backed up file back in place, or removes the file if
+// it did not exist.
+func (b *backup) restore() error {
+	if !b.exists {
+		if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(b.path), "."+filepath.Base(b.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(b.data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	os.Chmod(temp.Name(), b.mode)
+	os.Chown(temp.Name(), b.uid, b.gid)
+	err = os.Rename(temp.Name(), b.path)
+	if err != nil && strings.Contains(err.Error(), "device or resource busy") {
+		// The file is likely a mount, write to it instead.
+		return ioutil.WriteFile(b.path, b.data, b.mode)
+	}
+	return err
+}
+
+// rollback restores the backups after a reload command failed with
+// reloadErr, and runs cmds again: the failed command, and those that
+// already reloaded the new files. The returned error reports the outcome
+// of both runs.
+func rollback(cmds []command, backups []*backup, reloadErr error) error {
+	for _, b := range backups {
+		log.WithError(reloadErr).With(log.Fields{"dest": b.path}).Warning("Reload failed, restoring previous " + b.path)
+		if err := b.restore(); err != nil {
+			return fmt.Errorf("reload failed: %s; restoring previous %s failed: %s", reloadErr, b.path, err)
+		}
+	}
+	var lastErr error
+	for _, cmd := range cmds {
+		if err := runCommand(cmd); err != nil {
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return fmt.Errorf("reload failed: %s; restored previous config, but reloading it failed too: %s", reloadErr, lastErr)
+	}
+	return fmt.Errorf("reload failed: %s; restored previous config and reloaded it", reloadErr)
+}
@@ -0,0 +1,127 @@
+package template
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	util "github.com/kelseyhightower/confd/util"
+)
+
+// ErrUnknownTemplate is returned when triggering a template resource that
+// is not loaded.
+var ErrUnknownTemplate = errors.New("unknown template resource")
+
+// Status describes the last run of a template resource.
+type Status struct {
+	Name       string            `json:"name"`
+	Src        string            `json:"src"`
+	Dest       string            `json:"dest"`
+	LastRender time.Time         `json:"last_render"`
+	LastError  string            `json:"last_error,omitempty"`
+	LastIndex  map[string]uint64 `json:"last_index"`
+	Hash       string            `json:"hash,omitempty"`
+}
+
+// statusMap records the status of template resources by name. It is safe
+// for concurrent use.
+type statusMap struct {
+	mu sync.Mutex
+	m  map[string]*Status
+}
+
+func newStatusMap() *statusMap {
+	return &statusMap{m: make(map[string]*Status)}
+}
+
+// get returns the status of t, adding it if needed. The caller must hold
+// s.mu.
+func (s *statusMap) get(t *TemplateResource) *Status {
+	st, ok := s.m[t.name]
+	if !ok {
+		st = &Status{Name: t.name, LastIndex: make(map[string]uint64)}
+		s.m[t.name] = st
+	}
+	st.Src = t.Src
+	st.Dest = t.Dest
+	return st
+}
+
+// process processes the unit ts and records the outcome for each of its
+// template resources.
+func (s *statusMap) process(ts []*TemplateResource) error {
+	err := processUnit(ts)
+
+	hashes := make([]string, len(ts))
+	for i, t := range ts {
+		if err == nil && util.IsFileExist(t.Dest) {
+			if fi, err := util.FileStat(t.Dest); err == nil {
+				hashes[i] = fi.Md5
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, t := range ts {
+		st := s.get(t)
+		if err != nil {
+			st.LastError = err.Error()
+			continue
+		}
+		st.LastRender = time.Now()
+		st.LastError = ""
+		st.Hash = hashes[i]
+	}
+	return err
+}
+
+// setIndex records the index a store of t was last seen at.
+func (s *statusMap) setIndex(t *TemplateResource, store *storeBinding, index uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get(t).LastIndex[store.label()] = index
+}
+
+// track starts tracking the template resources in ts and drops the
+// status of any other.
+func (s *statusMap) track(ts []*TemplateResource) {
+	names := make(map[string]bool)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range ts {
+		names[t.name] = true
+		s.get(t)
+	}
+	for name := range s.m {
+		if !names[name] {
+			delete(s.m, name)
+		}
+	}
+}
+
+// has reports whether the template resource name is tracked.
+func (s *statusMap) has(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.m[name]
+	return ok
+}
+
+// list returns a copy of all statuses sorted by name.
+func (s *statusMap) list() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]Status, 0, len(s.m))
+	for _, st := range s.m {
+		c := *st
+		c.LastIndex = make(map[string]uint64, len(st.LastIndex))
+		for k, v := range st.LastIndex {
+			c.LastIndex[k] = v
+		}
+		statuses = append(statuses, c)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
@@ -1,10 +1,13 @@
 package template
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"path"
@@ -15,6 +18,7 @@ import (
 
 	util "github.com/kelseyhightower/confd/util"
 	"github.com/kelseyhightower/memkv"
+	"golang.org/x/crypto/openpgp"
 )
 
 func newFuncMap() map[string]interface{} {
@@ -54,12 +58,107 @@ func newFuncMap() map[string]interface{} {
 	return m
 }
 
+// dynamicFuncs are the functions whose result does not only depend on the
+// values of the keys. Templates calling them are rendered on every run.
+var dynamicFuncs = map[string]bool{
+	"datetime":   true,
+	"fileExists": true,
+	"getenv":     true,
+	"lookupIP":   true,
+	"lookupIPV4": true,
+	"lookupIPV6": true,
+	"lookupSRV":  true,
+}
+
 func addFuncs(out, in map[string]interface{}) {
 	for name, fn := range in {
 		out[name] = fn
 	}
 }
 
+var ErrNoKeyring = errors.New("no PGP private key configured")
+
+// addCryptFuncs adds the cget, cgets, cgetv and cgetvs functions to out.
+// They behave like their plain counterparts but decrypt each value with
+// decrypt before returning it.
+func addCryptFuncs(out map[string]interface{}, store *memkv.Store, decrypt func(data string) (string, error)) {
+	out["cget"] = func(key string) (memkv.KVPair, error) {
+		kv, err := store.Get(key)
+		if err != nil {
+			return kv, err
+		}
+		kv.Value, err = decrypt(kv.Value)
+		return kv, err
+	}
+	out["cgets"] = func(pattern string) (memkv.KVPairs, error) {
+		kvs, err := store.GetAll(pattern)
+		if err != nil {
+			return kvs, err
+		}
+		for i := range kvs {
+			if kvs[i].Value, err = decrypt(kvs[i].Value); err != nil {
+				return nil, err
+			}
+		}
+		return kvs, nil
+	}
+	out["cgetv"] = func(key string) (string, error) {
+		v, err := store.GetValue(key)
+		if err != nil {
+			return v, err
+		}
+		return decrypt(v)
+	}
+	out["cgetvs"] = func(pattern string) ([]string, error) {
+		vs, err := store.GetAllValues(pattern)
+		if err != nil {
+			return vs, err
+		}
+		for i := range vs {
+			if vs[i], err = decrypt(vs[i]); err != nil {
+				return nil, err
+			}
+		}
+		return vs, nil
+	}
+}
+
+// Decrypt decodes a value stored as base64(gpg(gzip(data))), the format
+// written by crypt, using the private keys in keyring.
+func Decrypt(keyring openpgp.EntityList, data string) (string, error) {
+	if len(keyring) == 0 {
+		return "", ErrNoKeyring
+	}
+	encrypted, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+	md, err := openpgp.ReadMessage(bytes.NewReader(encrypted), keyring, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	gz, err := gzip.NewReader(md.UnverifiedBody)
+	if err != nil {
+		return "", err
+	}
+	defer gz.Close()
+	b, err := ioutil.ReadAll(gz)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// readKeyring reads an armored PGP private keyring from path.
+func readKeyring(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return openpgp.ReadArmoredKeyRing(f)
+}
+
 // Seq creates a sequence of integers. It's named and used as GNU's seq.
 // Seq takes the first and the last element as arguments. So Seq(3, 5) will generate [3,4,5]
 func Seq(first, last int) []int {
@@ -14,6 +14,13 @@ const (
 	tmplFilePath = "test/templates/test.conf.tmpl"
 )
 
+// encryptedMary and encryptedAbc hold the values "mary" and "abc" encrypted
+// for the keypair in testdata/secring.asc.
+const (
+	encryptedMary = "wcBMAzukXeP6TUzjAQgATprNBPS27ASuFI/K3bDvVFheHhGRVodYZocbTqsxHrwuoDQ1cRjsQSP9TQIy+AZ9iJ0hAf8RJcrCwb6y/3BQdhLPSU6Ju6E36b6YvRW2D3Y1CzUfKm+JypNxoWgcfyPDAhPxMofzz26WNgDm+sDcO+MVJ+4RtPcgIYfaGUCDG2T8Ad2qJ4xadCgcnQgqVbqq3aoyRVyrfJzVGpUp518lyEC2vqten2rjxMq7z55wCgtwylPftoHKuGDqVshPK8qGym7Nc5nlkkP0rtT+oqI4g6Gi/Mb0RZmEI7CENXkMaQjMHl6PNIFNNFuoeYFrGlKYJjXzUMcp4jyu8JJ6tqPNTdLmAYrxrYkpye1f/e0CjVM3qXPnb4cgMiAV/cejUQU88t/jVCuedklt42lJ0PeIV/QM14NIOiG+gP0Tdr3ufYh95+PF0wdBLc36D+B5AA=="
+	encryptedAbc  = "wcBMAzukXeP6TUzjAQgAJXScy86ocJmu19jGPUByVuznVg46QrQT9VuVn9QXl9owZsixQxPMdLLtRDAQqjsf7/kgrMRo0Ag0sbcvB43I/bbrSJKzaSb2/rHNb0fVL9iplN/MQaVMzM5pHqs9SHdVlWY/4jiA548Ou3/AX2dRdFYO/FZheNLn73hELbIHo9Bklm7EFZh83WbiqFP0FvRhmEC7tVZBuRdaKDaufykoDr33qmOLFNbDP/iGKWITLAmRyxdwps7gRsOpSy7jkYUAtzs/ABfZobcpDlrOs/WydZX7VXN1rIb1GAQNEx4yAH0du9iBMvgEJxLAT9Zg87GL3Zaz3kCnUwEK7YhpVgyGbdLmAYKKjRG+NIA1lCuIB1vY57PMcajpvUEm2VWsry4PTIYos98qDn8WFaghFHTcg3VBq1vp0qmrg/WhKXokxSP6ZOLsBIjF4ZOO4GsA"
+)
+
 type templateTest struct {
 	desc        string                  // description of the test (for helpful errors)
 	toml        string                  // toml file contents
@@ -541,6 +548,111 @@ keys = [
 		updateStore: func(tr *TemplateResource) {
 			tr.store.Set("/test/count", "3")
 		},
+	}, templateTest{
+		desc: "cget test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+pgp_private_key = "testdata/secring.asc"
+keys = [
+    "/test/key",
+]
+`,
+		tmpl: `
+{{with cget "/test/key"}}
+key: {{base .Key}}
+val: {{.Value}}
+{{end}}
+`,
+		expected: `
+
+key: key
+val: abc
+
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/key", encryptedAbc)
+		},
+	}, templateTest{
+		desc: "cgets test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+pgp_private_key = "testdata/secring.asc"
+keys = [
+    "/test/user",
+    "/test/pass",
+]
+`,
+		tmpl: `
+{{range cgets "/test/*"}}
+key: {{.Key}}
+val: {{.Value}}
+{{end}}
+`,
+		expected: `
+
+key: /test/pass
+val: abc
+
+key: /test/user
+val: mary
+
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/user", encryptedMary)
+			tr.store.Set("/test/pass", encryptedAbc)
+		},
+	}, templateTest{
+		desc: "cgetv test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+pgp_private_key = "testdata/secring.asc"
+keys = [
+    "/test/user",
+]
+`,
+		tmpl: `
+user = {{cgetv "/test/user"}}
+`,
+		expected: `
+user = mary
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/user", encryptedMary)
+		},
+	}, templateTest{
+		desc: "cgetvs test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+pgp_private_key = "testdata/secring.asc"
+keys = [
+    "/test/user",
+    "/test/pass",
+]
+`,
+		tmpl: `
+{{range cgetvs "/test/*"}}
+val: {{.}}
+{{end}}
+`,
+		expected: `
+
+val: abc
+
+val: mary
+
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/user", encryptedMary)
+			tr.store.Set("/test/pass", encryptedAbc)
+		},
 	},
 }
 
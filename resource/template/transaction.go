@@ -0,0 +1,222 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/kelseyhightower/confd/log"
+)
+
+// units groups ts into the units that are processed together: a template
+// resource on its own, or all members of a transaction.
+func units(ts []*TemplateResource) [][]*TemplateResource {
+	var us [][]*TemplateResource
+	transactions := make(map[string]int)
+	for _, t := range ts {
+		if t.Transaction == "" {
+			us = append(us, []*TemplateResource{t})
+			continue
+		}
+		i, ok := transactions[t.Transaction]
+		if !ok {
+			i = len(us)
+			transactions[t.Transaction] = i
+			us = append(us, nil)
+		}
+		us[i] = append(us[i], t)
+	}
+	return us
+}
+
+// processUnit processes a unit returned by units.
+// It returns an *Error if any.
+func processUnit(u []*TemplateResource) error {
+	if t := u[0]; t.Transaction == "" {
+		if err := t.process(); err != nil {
+			return &Error{Err: err, Fields: log.Fields{"template": t.name, "dest": t.Dest}}
+		}
+		return nil
+	}
+	if err := processTransaction(u); err != nil {
+		return &Error{Err: err, Fields: log.Fields{"transaction": u[0].Transaction}}
+	}
+	return nil
+}
+
+// processTransaction processes the members of a transaction together. All
+// of them are staged and checked before any destination file is replaced,
+// and if a destination file cannot be replaced the others are restored.
+// The check and reload commands of the members run once each.
+// It returns an error if any.
+func processTransaction(ts []*TemplateResource) error {
+	name := ts[0].Transaction
+	logger := log.With(log.Fields{"transaction": name})
+	for _, t := range ts {
+		if err := t.setFileMode(); err != nil {
+			return err
+		}
+		if err := t.setVars(); err != nil {
+			return err
+		}
+	}
+	upToDate := true
+	for _, t := range ts {
+		upToDate = upToDate && t.upToDate()
+	}
+	if upToDate {
+		logger.Debug("Transaction " + name + " in sync, nothing changed since the last run")
+		for _, t := range ts {
+			templateFiles.WithLabelValues(t.name, "skipped").Inc()
+			templateLastSync.WithLabelValues(t.name).SetToCurrentTime()
+		}
+		return nil
+	}
+	var failed error
+	for _, t := range ts {
+		if failed = t.failedBefore(); failed == nil {
+			break
+		}
+	}
+	if failed != nil {
+		return fmt.Errorf("Transaction %s not synced: %s", name, failed)
+	}
+
+	for _, t := range ts {
+		if err := t.createStageFile(); err != nil {
+			return err
+		}
+		staged := t.StageFile.Name()
+		if t.keepStageFile {
+			t.logger().Info("Keeping staged file: " + staged)
+		} else {
+			defer os.Remove(staged)
+		}
+	}
+	var changed []*TemplateResource
+	for _, t := range ts {
+		if t.changed() {
+			changed = append(changed, t)
+			if t.showDiff {
+				if err := t.printDiff(); err != nil {
+					t.logger().WithError(err).Error("Cannot show changes to " + t.Dest + ": " + err.Error())
+				}
+			}
+		}
+	}
+	if ts[0].noop {
+		for _, t := range changed {
+			t.logger().Warning("Noop mode enabled. " + t.Dest + " will not be modified")
+		}
+		return nil
+	}
+	if len(changed) == 0 {
+		logger.Debug("Transaction " + name + " in sync")
+		transactionSynced(ts)
+		return nil
+	}
+
+	logger.Info(fmt.Sprintf("Transaction %s out of sync", name))
+	syncOnly := ts[0].syncOnly
+	if !syncOnly {
+		if err := checkTransaction(ts); err != nil {
+			return err
+		}
+	}
+
+	var backups []*backup
+	rollbackOnFailure := false
+	for _, t := range changed {
+		b, err := backupFile(t.Dest)
+		if err != nil {
+			restoreAll(backups)
+			return fmt.Errorf("Cannot back up %s, transaction %s rolled back: %s", t.Dest, name, err)
+		}
+		backups = append(backups, b)
+		if err := t.install(); err != nil {
+			restoreAll(backups)
+			return fmt.Errorf("Cannot replace %s, transaction %s rolled back: %s", t.Dest, name, err)
+		}
+		rollbackOnFailure = rollbackOnFailure || t.Rollback
+	}
+
+	if !syncOnly {
+		// Reload commands that already ran are run again on rollback, for
+		// their services to pick up the restored files too.
+		var reloaded []command
+		for _, t := range distinct(changed, func(t *TemplateResource) string { return t.reloadCommand().String() }) {
+			if err := t.reload(); err != nil {
+				commandFailures.WithLabelValues(t.name, "reload").Inc()
+				if rollbackOnFailure {
+					err = rollback(append(reloaded, t.reloadCommand()), backups, err)
+					for _, t := range ts {
+						t.rolledBack(err)
+					}
+				}
+				return fmt.Errorf("Reload of transaction %s failed: %s", name, err)
+			}
+			reloaded = append(reloaded, t.reloadCommand())
+		}
+	}
+	for _, t := range changed {
+		t.logger().Info("Target config " + t.Dest + " has been updated")
+		if t.updated != nil {
+			t.updated(t.Dest)
+		}
+	}
+	transactionSynced(ts)
+	return nil
+}
+
+// checkTransaction runs each distinct check command of the members of a
+// transaction once. Besides the staged file of the member in src, the
+// command can reference the staged file of every member by its destination
+// in staged, for example {{index .staged "/etc/app/main.conf"}}.
+func checkTransaction(ts []*TemplateResource) error {
+	staged := make(map[string]string)
+	for _, t := range ts {
+		staged[t.Dest] = t.StageFile.Name()
+	}
+	for _, t := range distinct(ts, func(t *TemplateResource) string { return t.checkCommand().String() }) {
+		data := map[string]interface{}{"src": t.StageFile.Name(), "staged": staged}
+		if err := runCheck(t.checkCommand(), data); err != nil {
+			commandFailures.WithLabelValues(t.name, "check").Inc()
+			return errors.New("Config check of transaction " + t.Transaction + " failed: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// transactionSynced records a successful sync of the members of a
+// transaction.
+func transactionSynced(ts []*TemplateResource) {
+	for _, t := range ts {
+		t.synced()
+		templateLastSync.WithLabelValues(t.name).SetToCurrentTime()
+	}
+}
+
+// restoreAll restores backups, logging failures.
+func restoreAll(backups []*backup) {
+	for _, b := range backups {
+		if err := b.restore(); err != nil {
+			log.WithError(err).With(log.Fields{"dest": b.path}).Error(fmt.Sprintf("Cannot restore %s: %s", b.path, err.Error()))
+		}
+	}
+}
+
+// distinct returns the first template resource in ts for each distinct,
+// non-empty value of key.
+func distinct(ts []*TemplateResource, key func(*TemplateResource) string) []*TemplateResource {
+	var out []*TemplateResource
+	seen := make(map[string]bool)
+	for _, t := range ts {
+		k := key(t)
+		if k == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		out = append(out, t)
+	}
+	return out
+}
@@ -0,0 +1,144 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/BurntSushi/toml"
+	"github.com/kelseyhightower/confd/backends"
+	util "github.com/kelseyhightower/confd/util"
+)
+
+// A Problem is an error in a template resource found by Validate.
+type Problem struct {
+	// Path is the path of the template resource file.
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Message
+}
+
+// Validate checks the template resources in the confdir and their
+// templates without contacting the backends. Backends in
+// config.StoreClients are only checked by name, and may be nil.
+// It returns the problems found, or an error if the template resources
+// cannot be listed.
+func Validate(config Config) ([]Problem, error) {
+	if !util.IsFileExist(config.ConfDir) {
+		return nil, fmt.Errorf("confdir '%s' does not exist", config.ConfDir)
+	}
+	paths, err := util.RecursiveFilesLookup(config.ConfigDir, "*toml")
+	if err != nil {
+		return nil, err
+	}
+
+	if config.StoreClient == nil {
+		config.StoreClient = noStore{}
+	}
+	clients := make(map[string]backends.StoreClient, len(config.StoreClients))
+	for name, c := range config.StoreClients {
+		if c == nil {
+			c = noStore{}
+		}
+		clients[name] = c
+	}
+	config.StoreClients = clients
+
+	var problems []Problem
+	for _, p := range paths {
+		for _, msg := range validateResource(p, config) {
+			problems = append(problems, Problem{Path: p, Message: msg})
+		}
+	}
+	return problems, nil
+}
+
+// validateResource returns the problems of the template resource at path.
+func validateResource(path string, config Config) []string {
+	var problems []string
+	tc := &TemplateResourceConfig{TemplateResource{Uid: -1, Gid: -1}}
+	md, err := toml.DecodeFile(path, &tc)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	for _, k := range md.Undecoded() {
+		problems = append(problems, fmt.Sprintf("unknown field %q", k.String()))
+	}
+
+	tr := &tc.TemplateResource
+	if tr.Dest == "" {
+		problems = append(problems, "missing dest")
+	}
+	if tr.Mode != "" {
+		if mode, err := strconv.ParseUint(tr.Mode, 0, 32); err != nil || mode > 07777 {
+			problems = append(problems, fmt.Sprintf("invalid mode %q", tr.Mode))
+		}
+	}
+	if tr.Uid < -1 {
+		problems = append(problems, fmt.Sprintf("invalid uid %d", tr.Uid))
+	}
+	if tr.Gid < -1 {
+		problems = append(problems, fmt.Sprintf("invalid gid %d", tr.Gid))
+	}
+	if tr.CheckCmd != "" {
+		if _, err := template.New("checkcmd").Parse(tr.CheckCmd); err != nil {
+			problems = append(problems, "invalid check_cmd: "+err.Error())
+		}
+	}
+	for _, arg := range tr.CheckArgs {
+		if _, err := template.New("checkargs").Parse(arg); err != nil {
+			problems = append(problems, "invalid check_args: "+err.Error())
+		}
+	}
+
+	src := filepath.Join(config.TemplateDir, tr.Src)
+	if tr.Src != "" && !util.IsFileExist(src) {
+		problems = append(problems, "missing template "+src)
+	}
+
+	t, err := NewTemplateResource(path, config)
+	if err != nil {
+		// Problems are reported with the path already.
+		return append(problems, strings.TrimPrefix(err.Error(), "Cannot process template resource "+path+" - "))
+	}
+	// Keys are joined to the prefix, so only keys going up with ".." can
+	// end up outside of it.
+	for _, s := range t.stores {
+		for _, k := range util.AppendPrefix(t.Prefix, s.keys) {
+			if !isBelow(k, t.Prefix) {
+				problems = append(problems, fmt.Sprintf("key %q is outside the prefix %q", k, t.Prefix))
+			}
+		}
+	}
+	if !util.IsFileExist(t.Src) {
+		return problems
+	}
+	if _, err := template.New(filepath.Base(t.Src)).Funcs(t.funcMap).ParseFiles(t.Src); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
+
+// isBelow reports whether the key k is prefix or below it.
+func isBelow(k, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return k == prefix || strings.HasPrefix(k, prefix+"/")
+}
+
+// noStore stands in for the backends when validating template resources.
+type noStore struct{}
+
+func (noStore) GetValues(keys []string) (map[string]string, error) {
+	return nil, errors.New("backends are not contacted when validating")
+}
+
+func (noStore) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	<-stopChan
+	return waitIndex, nil
+}
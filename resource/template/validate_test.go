@@ -0,0 +1,91 @@
+package template
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/kelseyhightower/confd/backends"
+	"github.com/kelseyhightower/confd/log"
+)
+
+func TestValidate(t *testing.T) {
+	log.SetLevel("warn")
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+	files := map[string]string{
+		"templates/good.tmpl": `{{getv "/vault/foo"}}`,
+		"templates/bad.tmpl":  `{{getv "/foo" | nope}}`,
+		"conf.d/good.toml": `
+[template]
+src = "good.tmpl"
+dest = "/tmp/good.conf"
+mode = "0640"
+keys = ["/vault/foo"]
+backends = ["vault"]
+`,
+		"conf.d/bad.toml": `
+[template]
+src = "bad.tmpl"
+dest = "/tmp/bad.conf"
+mode = "0999"
+uid = -2
+prefix = "/app"
+keys = ["foo", "../foo"]
+check_cmd = "test {{.src"
+reload_commd = "true"
+`,
+		"conf.d/missing.toml": `
+[template]
+src = "missing.tmpl"
+keys = ["/other/foo"]
+backends = ["other"]
+`,
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(tempConfDir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	c := Config{
+		ConfDir:      tempConfDir,
+		ConfigDir:    filepath.Join(tempConfDir, "conf.d"),
+		StoreClients: map[string]backends.StoreClient{"vault": nil},
+		TemplateDir:  filepath.Join(tempConfDir, "templates"),
+	}
+	problems, err := Validate(c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var got []string
+	for _, p := range problems {
+		rel, _ := filepath.Rel(tempConfDir, p.Path)
+		got = append(got, rel+": "+p.Message)
+	}
+	sort.Strings(got)
+	want := []string{
+		`conf.d/bad.toml: invalid check_cmd: template: checkcmd:1: unclosed action`,
+		`conf.d/bad.toml: invalid mode "0999"`,
+		`conf.d/bad.toml: invalid uid -2`,
+		`conf.d/bad.toml: key "/foo" is outside the prefix "/app"`,
+		`conf.d/bad.toml: template: bad.tmpl:1: function "nope" not defined`,
+		`conf.d/bad.toml: unknown field "template.reload_commd"`,
+		`conf.d/missing.toml: missing dest`,
+		`conf.d/missing.toml: missing template ` + filepath.Join(tempConfDir, "templates", "missing.tmpl"),
+		`conf.d/missing.toml: unknown backend "other"`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got problems %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got problem %q, want %q", got[i], want[i])
+		}
+	}
+}
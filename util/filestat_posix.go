@@ -30,3 +30,11 @@ func FileStat(name string) (fi FileInfo, err error) {
 	}
 	return fi, errors.New("File not found")
 }
+
+// FileOwner returns the owner of the file described by fi.
+func FileOwner(fi os.FileInfo) (uid, gid uint32) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Uid, st.Gid
+	}
+	return 0, 0
+}
@@ -0,0 +1,35 @@
+//go:build !windows
+// +build !windows
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// signals are the signals ParseSignal accepts.
+var signals = map[string]syscall.Signal{
+	"SIGHUP":   syscall.SIGHUP,
+	"SIGINT":   syscall.SIGINT,
+	"SIGQUIT":  syscall.SIGQUIT,
+	"SIGTERM":  syscall.SIGTERM,
+	"SIGUSR1":  syscall.SIGUSR1,
+	"SIGUSR2":  syscall.SIGUSR2,
+	"SIGWINCH": syscall.SIGWINCH,
+}
+
+// ParseSignal returns the signal named name, such as SIGHUP or HUP.
+func ParseSignal(name string) (os.Signal, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	s, ok := signals[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signal %q", name)
+	}
+	return s, nil
+}
@@ -0,0 +1,11 @@
+package util
+
+import (
+	"errors"
+	"os"
+)
+
+// ParseSignal returns an error, as signals are not supported on windows.
+func ParseSignal(name string) (os.Signal, error) {
+	return nil, errors.New("signals are not supported on windows")
+}